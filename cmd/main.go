@@ -1,48 +1,69 @@
 package main
 
 import (
+	"context"
+	"fmt"
 	"github.com/gorilla/mux"
 	"golang-stepik-2022q1/reditclone/config"
 	"golang-stepik-2022q1/reditclone/pkg/db"
 	"golang-stepik-2022q1/reditclone/pkg/handlers"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
 	"golang-stepik-2022q1/reditclone/pkg/middleware"
 	"golang-stepik-2022q1/reditclone/pkg/posts/delivery"
 	post_repo "golang-stepik-2022q1/reditclone/pkg/posts/repo"
 	post_uc "golang-stepik-2022q1/reditclone/pkg/posts/usecase"
-	session_repo "golang-stepik-2022q1/reditclone/pkg/session/repo"
+	"golang-stepik-2022q1/reditclone/pkg/reporting"
 	session_uc "golang-stepik-2022q1/reditclone/pkg/session/usecase"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
 	user_delivery "golang-stepik-2022q1/reditclone/pkg/users/delivery"
-	user_repo "golang-stepik-2022q1/reditclone/pkg/users/repo"
 	user_uc "golang-stepik-2022q1/reditclone/pkg/users/usecase"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-func NewServer(addr string) http.Server {
+func NewServer(addr string, store *storage, reporter reporting.Reporter) (*http.Server, error) {
+	accessLog, err := middleware.AccessLog(middleware.AccessLogOptions{
+		Format:        config.Cfg.AccessLogFormat,
+		Out:           os.Stdout,
+		SlowThreshold: config.Cfg.AccessLogSlow,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	realIP, err := middleware.RealIP(config.Cfg.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
 
-	postRepo := post_repo.NewMongoRepo(db.NewMongo())
-	postManager := post_uc.NewManager(postRepo)
+	postManager := post_uc.NewManager(store.posts, store.views)
 	postHandler := delivery.NewHandler(postManager)
 
-	userRepo := user_repo.NewSql(db.GetPostgres())
-	userManager := user_uc.NewManager(userRepo)
+	userManager := user_uc.NewManager(store.users)
 
-	sessionRepo := session_repo.NewRedis(db.NewRedis())
-	sessionManager := session_uc.NewManager(sessionRepo)
+	sessionManager := session_uc.NewManager(store.sessions)
 	userHandler := user_delivery.NewHandler(userManager, sessionManager)
 
 	apiHandler := mux.NewRouter()
 	auth := middleware.Authentication(sessionManager)
+	// anonymous users can read posts, logged in ones get their votes with posts
+	viewer := middleware.OptionalAuthentication(sessionManager)
 
 	apiHandler.HandleFunc("/api/register", userHandler.Register).Methods("POST")
 	apiHandler.HandleFunc("/api/login", userHandler.Login).Methods("POST")
 	// POSTS
-	apiHandler.HandleFunc("/api/post/{id}", postHandler.Get).Methods("GET")
-	apiHandler.HandleFunc("/api/posts/", postHandler.List).Methods("GET")
-	apiHandler.HandleFunc("/api/users/{username}", postHandler.GetByUser).Methods("GET")
+	apiHandler.Handle("/api/post/{id}", viewer(http.HandlerFunc(postHandler.Get))).Methods("GET")
+	apiHandler.Handle("/api/posts/", viewer(http.HandlerFunc(postHandler.List))).Methods("GET")
+	apiHandler.Handle("/api/posts/{category}", viewer(http.HandlerFunc(postHandler.ListByCategory))).Methods("GET")
+	apiHandler.Handle("/api/users/{username}", viewer(http.HandlerFunc(postHandler.GetByUser))).Methods("GET")
 	apiHandler.Handle("/api/posts", auth(http.HandlerFunc(postHandler.Create))).Methods("POST")
+	apiHandler.Handle("/api/post/{postId}", auth(http.HandlerFunc(postHandler.Edit))).Methods("PUT")
 	apiHandler.Handle("/api/post/{postId}", auth(http.HandlerFunc(postHandler.Delete))).Methods("DELETE")
+	apiHandler.Handle("/api/post/{postId}/revisions", auth(http.HandlerFunc(postHandler.Revisions))).Methods("GET")
 	// POST COMMENTS
 	apiHandler.Handle("/api/post/{postId}", auth(http.HandlerFunc(postHandler.AddComment))).Methods("POST")
 	apiHandler.Handle("/api/post/{postId}/{commentId}", auth(http.HandlerFunc(postHandler.DeleteComment))).Methods("DELETE")
@@ -50,23 +71,36 @@ func NewServer(addr string) http.Server {
 	apiHandler.Handle("/api/post/{postId}/upvote", auth(http.HandlerFunc(postHandler.Upvote))).Methods("GET")
 	apiHandler.Handle("/api/post/{postId}/downvote", auth(http.HandlerFunc(postHandler.Downvote))).Methods("GET")
 	apiHandler.Handle("/api/post/{postId}/unvote", auth(http.HandlerFunc(postHandler.Unvote))).Methods("GET")
+	apiHandler.Handle("/api/post/{postId}/votes", auth(http.HandlerFunc(postHandler.Voters))).Methods("GET")
+	// COMMENT VOTES
+	apiHandler.Handle("/api/post/{postId}/{commentId}/upvote", auth(http.HandlerFunc(postHandler.UpvoteComment))).Methods("GET")
+	apiHandler.Handle("/api/post/{postId}/{commentId}/downvote", auth(http.HandlerFunc(postHandler.DownvoteComment))).Methods("GET")
+	apiHandler.Handle("/api/post/{postId}/{commentId}/unvote", auth(http.HandlerFunc(postHandler.UnvoteComment))).Methods("GET")
 
 	apiHandler.Use(
-		middleware.SetupReqID,
-		middleware.InjectLogger,
-		middleware.SetupAccessLog,
+		middleware.RecordRoute,
+		accessLog,
+		middleware.Metrics,
 	)
 
 	siteMux := http.NewServeMux()
 	siteMux.Handle("/", handlers.StaticHandler)
 	siteMux.Handle("/api/", apiHandler)
+	// probes of orchestrator: liveness doesn't touch datastores, readiness pings all of them
+	siteMux.HandleFunc("/healthz", store.health.Live)
+	siteMux.HandleFunc("/readyz", store.health.Ready)
+	siteMux.Handle("/metrics", metrics.Handler())
 
-	return http.Server{
+	// every request is traced and panics of any handler or middleware are recovered, static files and probes included
+	handler := middleware.Recovery(reporter)(realIP(siteMux))
+	handler = middleware.Tracing(middleware.SetupReqID(middleware.InjectLogger(handler)))
+
+	return &http.Server{
 		Addr:         addr,
-		Handler:      siteMux,
+		Handler:      handler,
 		ReadTimeout:  10 * time.Second,
 		WriteTimeout: 10 * time.Second,
-	}
+	}, nil
 }
 
 func Init() {
@@ -76,12 +110,191 @@ func Init() {
 	if config.Cfg.Debug {
 		logLevel = log.DebugLevel
 	}
-	log.SetupLogger(logLevel)
+	err := log.SetupLogger(log.Options{
+		Level:    logLevel,
+		Format:   config.Cfg.LogFormat,
+		Levels:   config.Cfg.LogLevels,
+		Sampling: config.Cfg.LogSampling,
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Invalid logging config:", err)
+		os.Exit(2)
+	}
+}
+
+// migrateVotes moves votes embedded into post documents to votes collection
+func migrateVotes() {
+	client, err := db.NewMongo()
+	if err != nil {
+		log.Error("Votes migration failed", log.Fields{"error": err.Error()})
+		os.Exit(1)
+	}
+	defer client.Disconnect(context.Background())
+	postRepo := post_repo.NewMongoRepo(client)
+	migrated, err := postRepo.MigrateEmbeddedVotes(context.Background())
+	if err != nil {
+		log.Error("Votes migration failed", log.Fields{"error": err.Error(), "migrated": migrated})
+		client.Disconnect(context.Background())
+		os.Exit(1)
+	}
+	log.Info("Votes migrated", log.Fields{"posts": migrated})
+}
+
+// rebuildIndexes rebuilds Mongo indexes which differ from declared ones,
+// on startup such indexes are only reported
+func rebuildIndexes() {
+	client, err := db.NewMongo()
+	if err != nil {
+		log.Error("Indexes rebuild failed", log.Fields{"error": err.Error()})
+		os.Exit(1)
+	}
+	defer client.Disconnect(context.Background())
+	if err = post_repo.NewMongoRepo(client).RebuildIndexes(context.Background()); err != nil {
+		log.Error("Indexes rebuild failed", log.Fields{"error": err.Error()})
+		client.Disconnect(context.Background())
+		os.Exit(1)
+	}
+	log.Info("Indexes rebuilt")
+}
+
+// rerank recomputes stored ranks of all posts, it's needed after ranking formulas are changed.
+// Posts created before ranks were stored are reranked on server startup.
+func rerank() {
+	store, err := newStorage()
+	if err != nil {
+		log.Error("Rerank failed", log.Fields{"error": err.Error()})
+		os.Exit(1)
+	}
+	defer store.Close(context.Background())
+	reranked, err := post_uc.NewManager(store.posts, store.views).Rerank(context.Background())
+	if err != nil {
+		log.Error("Rerank failed", log.Fields{"error": err.Error(), "reranked": reranked})
+		store.Close(context.Background())
+		os.Exit(1)
+	}
+	log.Info("Posts reranked", log.Fields{"posts": reranked})
+}
+
+// rerankUnranked stores ranks of posts which have none, they are reported when it fails
+func rerankUnranked(ctx context.Context, manager *post_uc.Manager) {
+	reranked, err := manager.RerankUnranked(ctx)
+	if err != nil {
+		log.Warn("Posts without ranks aren't listed by ranked sorts, run rerank", log.Fields{"error": err.Error(), "reranked": reranked})
+		return
+	}
+	if reranked > 0 {
+		log.Info("Posts without ranks reranked", log.Fields{"posts": reranked})
+	}
 }
 
 func main() {
 	Init()
-	server := NewServer(":8008")
-	log.Info("Start server")
-	server.ListenAndServe()
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "migrate":
+			migrate(os.Args[2:])
+			return
+		case "migrate-votes":
+			migrateVotes()
+			return
+		case "rebuild-indexes":
+			rebuildIndexes()
+			return
+		case "rerank":
+			rerank()
+			return
+		}
+	}
+	if err := serve(":8008"); err != nil {
+		log.Error("Server failed", log.Fields{"error": err.Error()})
+		os.Exit(1)
+	}
+}
+
+// serve runs server until SIGINT or SIGTERM. On signal it stops accepting connections,
+// waits in-flight requests within shutdown timeout, flushes pending views and spans and closes database clients.
+func serve(addr string) error {
+	shutdownTracing, err := tracing.Setup(context.Background())
+	if err != nil {
+		return fmt.Errorf("tracing init failed: %w", err)
+	}
+	reporter, closeReporter, err := reporting.New(config.Cfg.ErrorReporter, config.Cfg.ErrorReportFile)
+	if err != nil {
+		shutdownTracing(context.Background())
+		return fmt.Errorf("error reporter init failed: %w", err)
+	}
+	// reporter is closed the last, panics of requests finishing during shutdown are still reported
+	defer func() {
+		if closeErr := closeReporter(); closeErr != nil {
+			log.Error("Cant close error reporter", log.Fields{"error": closeErr.Error()})
+		}
+	}()
+	store, err := newStorage()
+	if err != nil {
+		shutdownTracing(context.Background())
+		return fmt.Errorf("storage init failed: %w", err)
+	}
+
+	server, err := NewServer(addr, store, reporter)
+	if err != nil {
+		store.Close(context.Background())
+		shutdownTracing(context.Background())
+		return fmt.Errorf("server init failed: %w", err)
+	}
+
+	viewsCtx, stopViews := context.WithCancel(context.Background())
+	viewsDone := make(chan struct{})
+	go func() {
+		defer close(viewsDone)
+		store.views.Run(viewsCtx, config.Cfg.ViewsFlushInterval, store.posts)
+	}()
+
+	// posts created before ranks were stored aren't listed by ranked sorts until they are reranked
+	rerankCtx, stopRerank := context.WithCancel(context.Background())
+	rerankDone := make(chan struct{})
+	go func() {
+		defer close(rerankDone)
+		rerankUnranked(rerankCtx, post_uc.NewManager(store.posts, store.views))
+	}()
+
+	served := make(chan error, 1)
+	go func() {
+		log.Info("Start server", log.Fields{"addr": addr})
+		served <- server.ListenAndServe()
+	}()
+
+	signals, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	select {
+	case err = <-served:
+		// server didn't start, e.g. address is in use
+		err = fmt.Errorf("listen failed: %w", err)
+	case <-signals.Done():
+		log.Info("Shutting down", log.Fields{"timeout": config.Cfg.ShutdownTimeout.String()})
+	}
+	stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), config.Cfg.ShutdownTimeout)
+	defer cancel()
+	if shutdownErr := server.Shutdown(ctx); shutdownErr != nil {
+		log.Error("In-flight requests not finished", log.Fields{"error": shutdownErr.Error()})
+	}
+	// views counted by the last requests are flushed before repos are closed
+	stopViews()
+	select {
+	case <-viewsDone:
+	case <-ctx.Done():
+		log.Error("Pending views not flushed in time")
+	}
+	stopRerank()
+	select {
+	case <-rerankDone:
+	case <-ctx.Done():
+	}
+	store.Close(ctx)
+	if tracingErr := shutdownTracing(ctx); tracingErr != nil {
+		log.Error("Spans not exported", log.Fields{"error": tracingErr.Error()})
+	}
+	log.Info("Server stopped")
+	return err
 }
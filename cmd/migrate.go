@@ -0,0 +1,81 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"golang-stepik-2022q1/reditclone/config"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/db/migrations"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"os"
+)
+
+const migrateUsage = "usage: migrate up|down|status"
+
+// migrate runs "migrate up|down|status" subcommand against SQLite data file in embedded mode
+// or Postgres otherwise
+func migrate(args []string) {
+	if len(args) != 1 {
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+
+	var conn *sql.DB
+	var err error
+	dialect := migrations.Postgres
+	if config.Cfg.SqlitePath != "" {
+		dialect = migrations.Sqlite
+		conn, err = db.OpenSqlite(config.Cfg.SqlitePath)
+	} else {
+		conn, err = db.OpenPostgres()
+	}
+	if err != nil {
+		log.Error("Cant connect to database", log.Fields{"error": err.Error()})
+		os.Exit(1)
+	}
+	defer conn.Close()
+
+	migrator, err := migrations.New(conn, dialect)
+	if err != nil {
+		log.Error("Cant load migrations", log.Fields{"error": err.Error()})
+		os.Exit(1)
+	}
+
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, m := range applied {
+			log.Info("Migration applied", log.Fields{"version": m.Version, "name": m.Name})
+		}
+		if err != nil {
+			log.Error("Migration failed", log.Fields{"error": err.Error()})
+			os.Exit(1)
+		}
+		if len(applied) == 0 {
+			log.Info("Schema is up to date", log.Fields{"version": migrator.Latest()})
+		}
+	case "down":
+		reverted, err := migrator.Down()
+		if err != nil {
+			log.Error("Revert failed", log.Fields{"error": err.Error()})
+			os.Exit(1)
+		}
+		log.Info("Migration reverted", log.Fields{"version": reverted.Version, "name": reverted.Name})
+	case "status":
+		statuses, err := migrator.Status()
+		if err != nil {
+			log.Error("Cant get migrations status", log.Fields{"error": err.Error()})
+			os.Exit(1)
+		}
+		for _, status := range statuses {
+			applied := "pending"
+			if status.Applied != nil {
+				applied = status.Applied.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d %-20s %s\n", status.Version, status.Name, applied)
+		}
+	default:
+		fmt.Fprintln(os.Stderr, migrateUsage)
+		os.Exit(2)
+	}
+}
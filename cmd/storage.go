@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+	"golang-stepik-2022q1/reditclone/config"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/health"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	post_repo "golang-stepik-2022q1/reditclone/pkg/posts/repo"
+	post_uc "golang-stepik-2022q1/reditclone/pkg/posts/usecase"
+	session_repo "golang-stepik-2022q1/reditclone/pkg/session/repo"
+	session_uc "golang-stepik-2022q1/reditclone/pkg/session/usecase"
+	user_repo "golang-stepik-2022q1/reditclone/pkg/users/repo"
+	user_uc "golang-stepik-2022q1/reditclone/pkg/users/usecase"
+	"golang-stepik-2022q1/reditclone/pkg/views"
+	"time"
+)
+
+type viewCounter interface {
+	post_uc.ViewCounter
+	Run(ctx context.Context, interval time.Duration, sink views.Sink)
+}
+
+// storage holds repos of all domains
+type storage struct {
+	users    user_uc.Repo
+	sessions session_uc.Repo
+	posts    post_uc.Repo
+	views    viewCounter
+	// closers release database clients in reverse order of opening
+	closers []closer
+	health  *health.Checker
+}
+
+type closer struct {
+	name  string
+	close func(ctx context.Context) error
+}
+
+// newStorage creates repos: everything is kept in SQLite data file in embedded mode,
+// otherwise users live in Postgres, sessions and views in Redis and posts in configured backend.
+// Clients opened before failure are closed.
+func newStorage() (*storage, error) {
+	store := &storage{health: health.NewChecker(config.Cfg.HealthTimeout)}
+	if config.Cfg.SqlitePath != "" {
+		log.Info("Embedded mode, data is stored in SQLite", log.Fields{"path": config.Cfg.SqlitePath})
+		sqlite, err := db.NewSqlite(config.Cfg.SqlitePath)
+		if err != nil {
+			return nil, err
+		}
+		store.onClose("sqlite", closeSql(sqlite))
+		store.health.Add("sqlite", sqlite.PingContext)
+		store.users = user_repo.NewSql(sqlite)
+		store.sessions = session_repo.NewSql(sqlite)
+		store.posts = post_repo.NewSqliteRepo(sqlite)
+		store.views = views.NewMemCounter(config.Cfg.ViewsWindow)
+		return store, nil
+	}
+
+	redis, err := db.NewRedis()
+	if err != nil {
+		return nil, err
+	}
+	store.onClose("redis", func(context.Context) error { return redis.Close() })
+	store.health.Add("redis", redis.Ping)
+	pg, err := db.GetPostgres()
+	if err != nil {
+		store.Close(context.Background())
+		return nil, err
+	}
+	store.onClose("postgres", closeSql(pg))
+	store.health.Add("postgres", pg.PingContext)
+	store.users = user_repo.NewSql(pg)
+	store.sessions = session_repo.NewRedis(redis)
+	store.views = views.NewCounter(redis, config.Cfg.ViewsWindow)
+	if store.posts, err = store.newPostRepo(config.Cfg.PostsBackend, pg); err != nil {
+		store.Close(context.Background())
+		return nil, err
+	}
+	return store, nil
+}
+
+// newPostRepo creates posts storage of backend
+func (store *storage) newPostRepo(backend string, pg *sql.DB) (post_uc.Repo, error) {
+	switch backend {
+	case "mongo":
+		client, err := db.NewMongo()
+		if err != nil {
+			return nil, err
+		}
+		store.onClose("mongo", client.Disconnect)
+		store.health.Add("mongo", func(ctx context.Context) error {
+			return client.Ping(ctx, readpref.Primary())
+		})
+		return post_repo.NewMongoRepo(client), nil
+	case "postgres":
+		return post_repo.NewSqlRepo(pg), nil
+	case "memory":
+		log.Warn("Posts are stored in memory and will be lost on restart")
+		return post_repo.NewMemRepo(), nil
+	default:
+		return nil, fmt.Errorf("unknown posts backend %q", backend)
+	}
+}
+
+func (store *storage) onClose(name string, close func(ctx context.Context) error) {
+	store.closers = append(store.closers, closer{name: name, close: close})
+}
+
+// Close disconnects database clients, the last opened is closed first
+func (store *storage) Close(ctx context.Context) {
+	for idx := len(store.closers) - 1; idx >= 0; idx-- {
+		c := store.closers[idx]
+		if err := c.close(ctx); err != nil {
+			log.Error("Cant close database client", log.Fields{"client": c.name, "error": err.Error()})
+			continue
+		}
+		log.Debug("Database client closed", log.Fields{"client": c.name})
+	}
+	store.closers = nil
+}
+
+func closeSql(conn *sql.DB) func(context.Context) error {
+	return func(context.Context) error { return conn.Close() }
+}
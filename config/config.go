@@ -1,12 +1,31 @@
 package config
 
-import "github.com/kelseyhightower/envconfig"
+import (
+	"github.com/kelseyhightower/envconfig"
+	"time"
+)
 
 var Cfg Config
 
 type Config struct {
-	Debug  bool   `envconfig:"DEBUG" default:"true"`
-	JwtKey []byte `envconfig:"JWT_KEY" default:"super secret"`
+	Debug bool `envconfig:"DEBUG" default:"true"`
+	// Logging: format is "text" or "json", levels override level of packages, like "session/repo:warn,posts:debug",
+	// debug logs of sampled packages are written once per N entries with the same message, like "session/repo:100"
+	LogFormat   string            `envconfig:"LOG_FORMAT" default:"text"`
+	LogLevels   map[string]string `envconfig:"LOG_LEVELS" default:""`
+	LogSampling map[string]int    `envconfig:"LOG_SAMPLING" default:"session/repo:100"`
+	// Access log format is "log" (entry of application log), "combined" (Apache) or "json",
+	// combined and JSON lines are written to stdout. Slower requests are logged at Warn, zero disables it.
+	AccessLogFormat string        `envconfig:"ACCESS_LOG_FORMAT" default:"log"`
+	AccessLogSlow   time.Duration `envconfig:"ACCESS_LOG_SLOW" default:"1s"`
+	JwtKey          []byte        `envconfig:"JWT_KEY" default:"super secret"`
+	// Client address is taken from X-Forwarded-For and X-Real-IP only of requests from these proxies (IPs or CIDRs),
+	// it's used to tell anonymous viewers apart. Headers are ignored by default, they are set by clients at will.
+	TrustedProxies []string `envconfig:"TRUSTED_PROXIES" default:""`
+	// On SIGTERM in-flight requests are given this time to finish before connections are closed
+	ShutdownTimeout time.Duration `envconfig:"SHUTDOWN_TIMEOUT" default:"15s"`
+	// Every datastore ping of readiness check is limited by this timeout
+	HealthTimeout time.Duration `envconfig:"HEALTH_TIMEOUT" default:"1s"`
 	// Postgres config
 	DbName string `envconfig:"DB_NAME" default:"reddit"`
 	DbHost string `envconfig:"DB_HOST" default:"127.0.0.1"`
@@ -18,9 +37,29 @@ type Config struct {
 	RedisPort string `envconfig:"REDIS_PORT" default:"63790"`
 	RedisDb   int    `envconfig:"REDIS_DB" default:"0"`
 	RedisPwd  string `envconfig:"REDIS_PWD" default:""`
+	// Repo operation timeouts, request cancellation stops repo operations as well
+	DbReadTimeout  time.Duration `envconfig:"DB_READ_TIMEOUT" default:"3s"`
+	DbWriteTimeout time.Duration `envconfig:"DB_WRITE_TIMEOUT" default:"5s"`
+	// Embedded mode: when set, users, sessions and posts are kept in this SQLite file,
+	// so neither Postgres, Redis nor Mongo is needed
+	SqlitePath string `envconfig:"SQLITE_PATH" default:""`
+	// Posts storage: "mongo", "postgres" (next to users) or "memory" (for development, posts are lost on restart)
+	PostsBackend string `envconfig:"POSTS_BACKEND" default:"mongo"`
+	// Tracing: exporter is "otlp" (endpoint is set by standard OTEL_EXPORTER_OTLP_ENDPOINT),
+	// "file" (spans are written as JSON lines to TracingFile) or empty to not export spans
+	TracingExporter    string  `envconfig:"TRACING_EXPORTER" default:""`
+	TracingFile        string  `envconfig:"TRACING_FILE" default:"traces.json"`
+	TracingSampleRatio float64 `envconfig:"TRACING_SAMPLE_RATIO" default:"1"`
+	// Recovered panics are reported to ErrorReporter: "file" (reports are written as JSON lines to ErrorReportFile)
+	// or empty to only log them
+	ErrorReporter   string `envconfig:"ERROR_REPORTER" default:""`
+	ErrorReportFile string `envconfig:"ERROR_REPORT_FILE" default:"errors.json"`
 	// Mongo config
 	MongoHost string `envconfig:"MONGO_HOST" default:"127.0.0.1"`
 	MongoPort string `envconfig:"MONGO_PORT" default:"27017"`
+	// Views config: viewer is counted once per window, counted views are stored every flush interval
+	ViewsWindow        time.Duration `envconfig:"VIEWS_WINDOW" default:"1h"`
+	ViewsFlushInterval time.Duration `envconfig:"VIEWS_FLUSH_INTERVAL" default:"10s"`
 }
 
 func Load() {
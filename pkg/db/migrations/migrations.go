@@ -0,0 +1,298 @@
+package migrations
+
+import (
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	Postgres = "postgres"
+	Sqlite   = "sqlite"
+)
+
+var (
+	UnknownVersionError  = errors.New("Schema version is newer than known migrations")
+	NothingToRevertError = errors.New("No applied migrations to revert")
+	VersionChangedError  = errors.New("Schema version was changed by other process")
+)
+
+// lockKey is the key of Postgres advisory lock taken by migration transactions
+const lockKey = 727368657
+
+// migrations of every dialect are numbered files "0001_name.up.sql" and "0001_name.down.sql"
+//
+//go:embed postgres sqlite
+var files embed.FS
+
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var versionTable = `
+	CREATE TABLE IF NOT EXISTS schema_version (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied TIMESTAMP NOT NULL
+	)
+`
+
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+type Status struct {
+	Version int        `json:"version"`
+	Name    string     `json:"name"`
+	Applied *time.Time `json:"applied,omitempty"`
+}
+
+// Migrator applies and reverts migrations, applied versions are recorded in schema_version table
+type Migrator struct {
+	db         *sql.DB
+	dialect    string
+	migrations []*Migration
+}
+
+// New creates migrator with embedded migrations of the dialect
+func New(db *sql.DB, dialect string) (*Migrator, error) {
+	migrations, err := Load(files, dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, dialect: dialect, migrations: migrations}, nil
+}
+
+// Load reads migrations from dir ordered by version
+func Load(fsys fs.FS, dir string) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, dir)
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("unexpected migration file %s", entry.Name())
+		}
+		version, _ := strconv.Atoi(match[1])
+		data, err := fs.ReadFile(fsys, path.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		}
+		if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has different names: %s and %s", version, m.Name, match[2])
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]*Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d_%s should have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for idx, m := range migrations {
+		if m.Version != idx+1 {
+			return nil, fmt.Errorf("migration %d_%s is out of sequence", m.Version, m.Name)
+		}
+	}
+	return migrations, nil
+}
+
+// Latest returns version of the latest known migration
+func (m *Migrator) Latest() int {
+	return len(m.migrations)
+}
+
+// Version returns current schema version, zero for empty database
+func (m *Migrator) Version() (int, error) {
+	if err := m.createVersionTable(); err != nil {
+		return 0, err
+	}
+	return version(m.db)
+}
+
+// createVersionTable creates schema_version table under migration lock,
+// concurrent CREATE TABLE IF NOT EXISTS fails in Postgres
+func (m *Migrator) createVersionTable() error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if m.dialect == Postgres {
+		if err = m.lock(tx); err != nil {
+			return err
+		}
+	}
+	if _, err = tx.Exec(versionTable); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// lock takes migration lock till the end of transaction, so instances started together migrate one by one.
+// SQLite locks the whole database on the first write, so a write which changes nothing takes the lock up front.
+func (m *Migrator) lock(tx *sql.Tx) error {
+	var err error
+	if m.dialect == Postgres {
+		_, err = tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockKey)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_version WHERE version < 0`)
+	}
+	return err
+}
+
+type querier interface {
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+func version(q querier) (int, error) {
+	var version sql.NullInt64
+	if err := q.QueryRow(`SELECT MAX(version) FROM schema_version`).Scan(&version); err != nil {
+		return 0, err
+	}
+	return int(version.Int64), nil
+}
+
+// Check refuses schema created by newer version of the app
+func (m *Migrator) Check() (int, error) {
+	version, err := m.Version()
+	if err != nil {
+		return 0, err
+	}
+	if version > m.Latest() {
+		return version, fmt.Errorf("%w: %d, latest known is %d", UnknownVersionError, version, m.Latest())
+	}
+	return version, nil
+}
+
+// Up applies all pending migrations, every one in its own transaction.
+// Migrations applied meanwhile by other instance of the app are skipped.
+func (m *Migrator) Up() ([]*Migration, error) {
+	version, err := m.Check()
+	if err != nil {
+		return nil, err
+	}
+	applied := make([]*Migration, 0, len(m.migrations))
+	for _, migration := range m.migrations[version:] {
+		done, err := m.apply(migration.Up, migration.Version-1, func(tx *sql.Tx) error {
+			_, err := tx.Exec(
+				`INSERT INTO schema_version (version, name, applied) VALUES ($1, $2, $3)`,
+				migration.Version, migration.Name, time.Now().UTC(),
+			)
+			return err
+		})
+		if err != nil {
+			return applied, fmt.Errorf("migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+		if done {
+			applied = append(applied, migration)
+		}
+	}
+	return applied, nil
+}
+
+// Down reverts the latest applied migration
+func (m *Migrator) Down() (*Migration, error) {
+	version, err := m.Check()
+	if err != nil {
+		return nil, err
+	}
+	if version == 0 {
+		return nil, NothingToRevertError
+	}
+	migration := m.migrations[version-1]
+	done, err := m.apply(migration.Down, migration.Version, func(tx *sql.Tx) error {
+		_, err := tx.Exec(`DELETE FROM schema_version WHERE version = $1`, migration.Version)
+		return err
+	})
+	if err == nil && !done {
+		err = VersionChangedError
+	}
+	if err != nil {
+		return nil, fmt.Errorf("revert of %d_%s failed: %w", migration.Version, migration.Name, err)
+	}
+	return migration, nil
+}
+
+// Status lists known migrations with time they were applied
+func (m *Migrator) Status() ([]Status, error) {
+	if _, err := m.Check(); err != nil {
+		return nil, err
+	}
+	rows, err := m.db.Query(`SELECT version, applied FROM schema_version`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var at time.Time
+		if err = rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	statuses := make([]Status, 0, len(m.migrations))
+	for _, migration := range m.migrations {
+		status := Status{Version: migration.Version, Name: migration.Name}
+		if at, ok := applied[migration.Version]; ok {
+			status.Applied = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// apply runs migration script and records it in one transaction, if schema is still of from version.
+// Transaction takes migration lock before the version check, so concurrent instances never apply the same script:
+// the one waiting for the lock finds the version changed and skips the script (false is returned).
+func (m *Migrator) apply(script string, from int, record func(tx *sql.Tx) error) (bool, error) {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if err = m.lock(tx); err != nil {
+		return false, err
+	}
+	current, err := version(tx)
+	if err != nil || current != from {
+		return false, err
+	}
+	if _, err = tx.Exec(script); err != nil {
+		return false, err
+	}
+	if err = record(tx); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
@@ -0,0 +1,184 @@
+package migrations
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	_ "modernc.org/sqlite"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func TestLoad(t *testing.T) {
+	file := &fstest.MapFile{Data: []byte("SELECT 1;")}
+	for _, tt := range [...]struct {
+		name     string
+		files    fstest.MapFS
+		expected int
+		fails    bool
+	}{
+		{
+			name: "OK",
+			files: fstest.MapFS{
+				"m/0002_b.up.sql": file, "m/0002_b.down.sql": file,
+				"m/0001_a.up.sql": file, "m/0001_a.down.sql": file,
+			},
+			expected: 2,
+		},
+		{"Missing down", fstest.MapFS{"m/0001_a.up.sql": file}, 0, true},
+		{"Gap in versions", fstest.MapFS{"m/0002_b.up.sql": file, "m/0002_b.down.sql": file}, 0, true},
+		{"Unexpected file", fstest.MapFS{"m/readme.md": file}, 0, true},
+		{"Different names", fstest.MapFS{"m/0001_a.up.sql": file, "m/0001_b.down.sql": file}, 0, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			migrations, err := Load(tt.files, "m")
+			if tt.fails {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, migrations, tt.expected)
+			for idx, m := range migrations {
+				assert.Equal(t, idx+1, m.Version)
+			}
+		})
+	}
+}
+
+// both dialects have the same migrations, so they can be switched without surprises
+func TestDialectsInSync(t *testing.T) {
+	pg, err := Load(files, Postgres)
+	require.NoError(t, err)
+	sqlite, err := Load(files, Sqlite)
+	require.NoError(t, err)
+	require.Equal(t, len(pg), len(sqlite))
+	for idx := range pg {
+		assert.Equal(t, pg[idx].Name, sqlite[idx].Name)
+	}
+}
+
+func TestMigrator(t *testing.T) {
+	db, err := sql.Open("sqlite", "file:"+filepath.Join(t.TempDir(), "test.db")+"?_pragma=foreign_keys(1)")
+	require.NoError(t, err)
+	defer db.Close()
+	migrator, err := New(db, Sqlite)
+	require.NoError(t, err)
+
+	version, err := migrator.Version()
+	require.NoError(t, err)
+	assert.Equal(t, 0, version)
+
+	applied, err := migrator.Up()
+	require.NoError(t, err)
+	assert.Len(t, applied, migrator.Latest())
+
+	// repeated up changes nothing
+	applied, err = migrator.Up()
+	require.NoError(t, err)
+	assert.Empty(t, applied)
+
+	statuses, err := migrator.Status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		assert.NotNil(t, status.Applied, status.Name)
+	}
+
+	// every down reverts its up
+	for version := migrator.Latest(); version > 0; version-- {
+		reverted, err := migrator.Down()
+		require.NoError(t, err)
+		assert.Equal(t, version, reverted.Version)
+	}
+	_, err = migrator.Down()
+	assert.Equal(t, NothingToRevertError, err)
+	var tables int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name != 'schema_version' AND name NOT LIKE 'sqlite_%'`).Scan(&tables))
+	assert.Equal(t, 0, tables)
+
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	// schema of newer app version is refused
+	_, err = db.Exec(`INSERT INTO schema_version (version, name, applied) VALUES ($1, 'future', CURRENT_TIMESTAMP)`, migrator.Latest()+1)
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	assert.True(t, errors.Is(err, UnknownVersionError))
+	_, err = migrator.Down()
+	assert.True(t, errors.Is(err, UnknownVersionError))
+}
+
+// instances started together apply every migration once, the rest find it applied meanwhile
+func TestMigrator_Concurrent(t *testing.T) {
+	dsn := "file:" + filepath.Join(t.TempDir(), "test.db") + "?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+	applied := make(chan int, 4)
+	errs := make(chan error, 4)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			db, err := sql.Open("sqlite", dsn)
+			if err != nil {
+				errs <- err
+				return
+			}
+			defer db.Close()
+			db.SetMaxOpenConns(1)
+			migrator, err := New(db, Sqlite)
+			if err == nil {
+				var migrations []*Migration
+				migrations, err = migrator.Up()
+				applied <- len(migrations)
+			}
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		require.NoError(t, <-errs)
+	}
+	close(applied)
+	total := 0
+	for count := range applied {
+		total += count
+	}
+	migrations, err := Load(files, Sqlite)
+	require.NoError(t, err)
+	assert.Equal(t, len(migrations), total)
+}
+
+// databases created before migrations have users table of the first app version,
+// it's brought into shape by the first migration. Runs only when POSTGRES_TEST_DSN is set.
+func TestMigrator_PostgresBaseline(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	db, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer db.Close()
+	// search path is set per connection, the schema is used by the only one
+	db.SetMaxOpenConns(1)
+	schema := fmt.Sprintf("migrations_test_%d", time.Now().UnixNano())
+	_, err = db.Exec(`CREATE SCHEMA ` + schema)
+	require.NoError(t, err)
+	defer db.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+	_, err = db.Exec(`SET search_path TO ` + schema)
+	require.NoError(t, err)
+
+	_, err = db.Exec(`CREATE TABLE users (id SERIAL PRIMARY KEY, name TEXT NOT NULL, pass_hash TEXT NOT NULL)`)
+	require.NoError(t, err)
+	_, err = db.Exec(`INSERT INTO users (name, pass_hash) VALUES ('john', 'hash')`)
+	require.NoError(t, err)
+
+	migrator, err := New(db, Postgres)
+	require.NoError(t, err)
+	_, err = migrator.Up()
+	require.NoError(t, err)
+
+	var role string
+	require.NoError(t, db.QueryRow(`SELECT role FROM users WHERE name = 'john'`).Scan(&role))
+	assert.Equal(t, "user", role)
+}
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS users (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL,
+    pass_hash TEXT NOT NULL,
+    role TEXT NOT NULL DEFAULT 'user'
+);
+-- databases created before migrations have users table without role
+ALTER TABLE users ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'user';
@@ -0,0 +1,4 @@
+DROP TABLE votes;
+DROP TABLE comments;
+DROP TABLE post_revisions;
+DROP TABLE posts;
@@ -0,0 +1,50 @@
+CREATE TABLE IF NOT EXISTS posts (
+    id TEXT PRIMARY KEY,
+    type TEXT NOT NULL,
+    title TEXT NOT NULL,
+    category TEXT NOT NULL,
+    text TEXT NOT NULL DEFAULT '',
+    url TEXT NOT NULL DEFAULT '',
+    author_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    views INTEGER NOT NULL DEFAULT 0,
+    upvotes INTEGER NOT NULL DEFAULT 0,
+    downvotes INTEGER NOT NULL DEFAULT 0,
+    score INTEGER NOT NULL DEFAULT 0,
+    upvote_percentage INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP NOT NULL,
+    edited TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS posts_created_idx ON posts (created DESC, id DESC);
+
+CREATE TABLE IF NOT EXISTS post_revisions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    title TEXT NOT NULL,
+    text TEXT NOT NULL,
+    created TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS comments (
+    id TEXT PRIMARY KEY,
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    parent_id TEXT REFERENCES comments (id) ON DELETE CASCADE,
+    author_id INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    body TEXT NOT NULL,
+    deleted BOOLEAN NOT NULL DEFAULT FALSE,
+    upvotes INTEGER NOT NULL DEFAULT 0,
+    downvotes INTEGER NOT NULL DEFAULT 0,
+    score INTEGER NOT NULL DEFAULT 0,
+    upvote_percentage INTEGER NOT NULL DEFAULT 0,
+    created TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS comments_post_idx ON comments (post_id);
+
+CREATE TABLE IF NOT EXISTS votes (
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    target TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    vote INTEGER NOT NULL,
+    voted TIMESTAMP NOT NULL,
+    PRIMARY KEY (target, user_id)
+);
+CREATE INDEX IF NOT EXISTS votes_post_idx ON votes (post_id);
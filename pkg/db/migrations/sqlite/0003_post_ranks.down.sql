@@ -0,0 +1 @@
+DROP TABLE post_ranks;
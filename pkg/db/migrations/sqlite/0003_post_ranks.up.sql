@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS post_ranks (
+    post_id TEXT NOT NULL REFERENCES posts (id) ON DELETE CASCADE,
+    sort TEXT NOT NULL,
+    rank REAL NOT NULL,
+    PRIMARY KEY (post_id, sort)
+);
+CREATE INDEX IF NOT EXISTS post_ranks_sort_idx ON post_ranks (sort, rank DESC, post_id DESC);
@@ -7,21 +7,21 @@ import (
 	"go.mongodb.org/mongo-driver/mongo/options"
 	"go.mongodb.org/mongo-driver/mongo/readpref"
 	"golang-stepik-2022q1/reditclone/config"
-	"golang-stepik-2022q1/reditclone/pkg/log"
 	"time"
 )
 
-func NewMongo() *mongo.Client {
+func NewMongo() (*mongo.Client, error) {
 	uri := fmt.Sprintf("mongodb://%s:%s", config.Cfg.MongoHost, config.Cfg.MongoPort)
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
-		log.Error("cant connect to mongo", log.Fields{"error": err.Error()})
+		return nil, fmt.Errorf("cant connect to mongo: %w", err)
 	}
 	err = client.Ping(ctx, readpref.Primary())
 	if err != nil {
-		log.Error("cant ping mongo", log.Fields{"error": err.Error()})
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("cant ping mongo: %w", err)
 	}
-	return client
+	return client, nil
 }
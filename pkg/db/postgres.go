@@ -5,19 +5,25 @@ import (
 	"fmt"
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"golang-stepik-2022q1/reditclone/config"
+	"golang-stepik-2022q1/reditclone/pkg/db/migrations"
 	"golang-stepik-2022q1/reditclone/pkg/log"
 )
 
-var initial = `
-	DROP TABLE IF EXISTS users;
-	CREATE TABLE IF NOT EXISTS users (
-            id SERIAL PRIMARY KEY,
-            name TEXT NOT NULL,
-			pass_hash TEXT NOT NULL
-	)
-`
+// GetPostgres connects to Postgres and brings schema up to date
+func GetPostgres() (*sql.DB, error) {
+	db, err := OpenPostgres()
+	if err != nil {
+		return nil, err
+	}
+	if err = Migrate(db, migrations.Postgres); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
 
-func GetPostgres() *sql.DB {
+// OpenPostgres connects to Postgres without touching the schema
+func OpenPostgres() (*sql.DB, error) {
 	dsn := fmt.Sprintf(
 		"user=%s dbname=%s password=%s host=%s port=%s sslmode=disable",
 		config.Cfg.DbUser,
@@ -28,17 +34,26 @@ func GetPostgres() *sql.DB {
 	)
 	db, err := sql.Open("pgx", dsn)
 	if err != nil {
-		log.Error("cant parse config", log.Fields{"error": err})
+		return nil, fmt.Errorf("cant parse postgres config: %w", err)
 	}
 	err = db.Ping() // вот тут будет первое подключение к базе
 	if err != nil {
-		log.Error("ping failed", log.Fields{"error": err})
+		db.Close()
+		return nil, fmt.Errorf("cant ping postgres: %w", err)
 	}
 	db.SetMaxOpenConns(10)
+	return db, nil
+}
 
-	_, err = db.Exec(initial)
+// Migrate applies pending migrations, schema of unknown (newer) version is refused
+func Migrate(db *sql.DB, dialect string) error {
+	migrator, err := migrations.New(db, dialect)
 	if err != nil {
-		panic(err.Error())
+		return err
+	}
+	applied, err := migrator.Up()
+	for _, m := range applied {
+		log.Info("Migration applied", log.Fields{"version": m.Version, "name": m.Name})
 	}
-	return db
+	return err
 }
@@ -8,20 +8,24 @@ import (
 	"golang-stepik-2022q1/reditclone/pkg/log"
 )
 
-func NewRedis() *RedisClient {
+func NewRedis() (*RedisClient, error) {
 	rdb := redis.NewClient(&redis.Options{
 		Addr:     fmt.Sprintf("%s:%s", config.Cfg.RedisHost, config.Cfg.RedisPort),
 		Password: config.Cfg.RedisPwd, // no password set
 		DB:       config.Cfg.RedisDb,  // use default DB
 	})
-	checkConnection(rdb)
-	return &RedisClient{rdb}
+	if err := checkConnection(rdb); err != nil {
+		rdb.Close()
+		return nil, err
+	}
+	return &RedisClient{rdb}, nil
 }
 
-func checkConnection(client *redis.Client) {
+func checkConnection(client *redis.Client) error {
 	_, err := client.Ping(context.Background()).Result()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("cant ping redis: %w", err)
 	}
 	log.Debug("Connection to Redis established")
+	return nil
 }
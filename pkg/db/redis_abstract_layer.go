@@ -9,6 +9,7 @@ import (
 type IRedisClient interface {
 	Set(ctx context.Context, key string, value interface{}, expiration time.Duration) IRedisStatusCmd
 	Get(ctx context.Context, key string) IRedisStatusCmd
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) IRedisCmd
 }
 
 type IRedisStatusCmd interface {
@@ -16,10 +17,31 @@ type IRedisStatusCmd interface {
 	Result() (string, error)
 }
 
+// IRedisCmd is a result of command with arbitrary reply, like script evaluation
+type IRedisCmd interface {
+	Err() error
+	Result() (interface{}, error)
+}
+
 type RedisClient struct {
 	cli *redis.Client
 }
 
+// WrapRedis wraps already connected client
+func WrapRedis(cli *redis.Client) *RedisClient {
+	return &RedisClient{cli}
+}
+
+// Close closes the client, it's not part of IRedisClient as repos never own the connection
+func (rc *RedisClient) Close() error {
+	return rc.cli.Close()
+}
+
+// Ping checks connection, it's used by readiness check
+func (rc *RedisClient) Ping(ctx context.Context) error {
+	return rc.cli.Ping(ctx).Err()
+}
+
 func (rc *RedisClient) Set(ctx context.Context, key string, value interface{}, expiration time.Duration) IRedisStatusCmd {
 	cmd := rc.cli.Set(ctx, key, value, expiration)
 	return &RedisStatusCmd{cmd}
@@ -30,6 +52,10 @@ func (rc *RedisClient) Get(ctx context.Context, key string) IRedisStatusCmd {
 	return &RedisStringCmd{cmd}
 }
 
+func (rc *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) IRedisCmd {
+	return rc.cli.Eval(ctx, script, keys, args...)
+}
+
 type RedisStatusCmd struct {
 	cmd *redis.StatusCmd
 }
@@ -35,6 +35,25 @@ func (m *MockIRedisClient) EXPECT() *MockIRedisClientMockRecorder {
 	return m.recorder
 }
 
+// Eval mocks base method.
+func (m *MockIRedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) IRedisCmd {
+	m.ctrl.T.Helper()
+	varargs := []interface{}{ctx, script, keys}
+	for _, a := range args {
+		varargs = append(varargs, a)
+	}
+	ret := m.ctrl.Call(m, "Eval", varargs...)
+	ret0, _ := ret[0].(IRedisCmd)
+	return ret0
+}
+
+// Eval indicates an expected call of Eval.
+func (mr *MockIRedisClientMockRecorder) Eval(ctx, script, keys interface{}, args ...interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	varargs := append([]interface{}{ctx, script, keys}, args...)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Eval", reflect.TypeOf((*MockIRedisClient)(nil).Eval), varargs...)
+}
+
 // Get mocks base method.
 func (m *MockIRedisClient) Get(ctx context.Context, key string) IRedisStatusCmd {
 	m.ctrl.T.Helper()
@@ -114,3 +133,55 @@ func (mr *MockIRedisStatusCmdMockRecorder) Result() *gomock.Call {
 	mr.mock.ctrl.T.Helper()
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Result", reflect.TypeOf((*MockIRedisStatusCmd)(nil).Result))
 }
+
+// MockIRedisCmd is a mock of IRedisCmd interface.
+type MockIRedisCmd struct {
+	ctrl     *gomock.Controller
+	recorder *MockIRedisCmdMockRecorder
+}
+
+// MockIRedisCmdMockRecorder is the mock recorder for MockIRedisCmd.
+type MockIRedisCmdMockRecorder struct {
+	mock *MockIRedisCmd
+}
+
+// NewMockIRedisCmd creates a new mock instance.
+func NewMockIRedisCmd(ctrl *gomock.Controller) *MockIRedisCmd {
+	mock := &MockIRedisCmd{ctrl: ctrl}
+	mock.recorder = &MockIRedisCmdMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockIRedisCmd) EXPECT() *MockIRedisCmdMockRecorder {
+	return m.recorder
+}
+
+// Err mocks base method.
+func (m *MockIRedisCmd) Err() error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Err")
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// Err indicates an expected call of Err.
+func (mr *MockIRedisCmdMockRecorder) Err() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Err", reflect.TypeOf((*MockIRedisCmd)(nil).Err))
+}
+
+// Result mocks base method.
+func (m *MockIRedisCmd) Result() (interface{}, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Result")
+	ret0, _ := ret[0].(interface{})
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Result indicates an expected call of Result.
+func (mr *MockIRedisCmdMockRecorder) Result() *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Result", reflect.TypeOf((*MockIRedisCmd)(nil).Result))
+}
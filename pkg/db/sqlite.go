@@ -0,0 +1,35 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"golang-stepik-2022q1/reditclone/pkg/db/migrations"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	_ "modernc.org/sqlite"
+)
+
+// NewSqlite opens SQLite data file and brings schema up to date
+func NewSqlite(path string) (*sql.DB, error) {
+	db, err := OpenSqlite(path)
+	if err != nil {
+		return nil, err
+	}
+	if err = Migrate(db, migrations.Sqlite); err != nil {
+		db.Close()
+		return nil, err
+	}
+	log.Debug("SQLite data file opened", log.Fields{"path": path})
+	return db, nil
+}
+
+// OpenSqlite opens SQLite data file without touching the schema
+func OpenSqlite(path string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)", path)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, single connection serializes transactions instead of failing them
+	db.SetMaxOpenConns(1)
+	return db, nil
+}
@@ -0,0 +1,25 @@
+package db
+
+import (
+	"context"
+	"golang-stepik-2022q1/reditclone/config"
+	"time"
+)
+
+// ReadContext limits repo read operation by configured timeout
+func ReadContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, config.Cfg.DbReadTimeout)
+}
+
+// WriteContext limits repo write operation by configured timeout
+func WriteContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, config.Cfg.DbWriteTimeout)
+}
+
+// withTimeout keeps ctx deadline when it's earlier, zero timeout means no limit besides ctx
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
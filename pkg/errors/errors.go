@@ -1,5 +1,73 @@
 package errors
 
+import (
+	errors2 "errors"
+	"net/http"
+)
+
+// Code is machine-readable kind of an API error, every code is sent with its own HTTP status
+type Code string
+
+const (
+	CodeValidation      Code = "validation"
+	CodeNotFound        Code = "not_found"
+	CodeForbidden       Code = "forbidden"
+	CodeConflict        Code = "conflict"
+	CodeUnauthenticated Code = "unauthenticated"
+	CodeRateLimited     Code = "rate_limited"
+	CodeInternal        Code = "internal"
+)
+
+var statuses = map[Code]int{
+	CodeValidation:      http.StatusUnprocessableEntity,
+	CodeNotFound:        http.StatusNotFound,
+	CodeForbidden:       http.StatusForbidden,
+	CodeConflict:        http.StatusConflict,
+	CodeUnauthenticated: http.StatusUnauthorized,
+	CodeRateLimited:     http.StatusTooManyRequests,
+	CodeInternal:        http.StatusInternalServerError,
+}
+
+func (c Code) Status() int {
+	if status, ok := statuses[c]; ok {
+		return status
+	}
+	return http.StatusInternalServerError
+}
+
+// Error is implemented by errors which can be shown to API clients
+type Error interface {
+	error
+	Code() Code
+}
+
+// CodeOf returns code of the API error found in err chain, any other error is internal
+func CodeOf(err error) Code {
+	var apiErr Error
+	if errors2.As(err, &apiErr) {
+		return apiErr.Code()
+	}
+	return CodeInternal
+}
+
+// Message returns text of the error for API clients,
+// details of internal errors (like database errors) are never shown to them
+func Message(err error) string {
+	if CodeOf(err) == CodeInternal {
+		return "Internal error"
+	}
+	return err.Error()
+}
+
+// Fields returns per-field details of validation error found in err chain
+func Fields(err error) []FieldError {
+	var validationErr *ValidationError
+	if errors2.As(err, &validationErr) {
+		return validationErr.Fields
+	}
+	return nil
+}
+
 type InternalError struct {
 	Details string
 }
@@ -7,3 +75,95 @@ type InternalError struct {
 func (err InternalError) Error() string {
 	return err.Details
 }
+
+func (InternalError) Code() Code {
+	return CodeInternal
+}
+
+// ForbiddenError means user is authenticated but not allowed to do the action
+type ForbiddenError struct {
+	Action string
+}
+
+func (err ForbiddenError) Error() string {
+	return "Not allowed to " + err.Action
+}
+
+func (ForbiddenError) Code() Code {
+	return CodeForbidden
+}
+
+// FieldError describes invalid field of the request, it's named like the field of request JSON
+type FieldError struct {
+	Param string `json:"param"`
+	Msg   string `json:"msg"`
+}
+
+// ValidationError means request is malformed or its fields are invalid
+type ValidationError struct {
+	Details string
+	Fields  []FieldError
+}
+
+// NewValidation returns validation error of a single field
+func NewValidation(details, param, msg string) *ValidationError {
+	return &ValidationError{Details: details, Fields: []FieldError{{Param: param, Msg: msg}}}
+}
+
+func (err *ValidationError) Error() string {
+	return err.Details
+}
+
+func (*ValidationError) Code() Code {
+	return CodeValidation
+}
+
+type NotFoundError struct {
+	Resource string
+}
+
+func (err NotFoundError) Error() string {
+	return err.Resource + " not found"
+}
+
+func (NotFoundError) Code() Code {
+	return CodeNotFound
+}
+
+// ConflictError means the request contradicts current state, like registration of taken username
+type ConflictError struct {
+	Details string
+}
+
+func (err ConflictError) Error() string {
+	return err.Details
+}
+
+func (ConflictError) Code() Code {
+	return CodeConflict
+}
+
+// UnauthenticatedError means credentials or token of the request are missing or invalid
+type UnauthenticatedError struct {
+	Details string
+}
+
+func (err UnauthenticatedError) Error() string {
+	return err.Details
+}
+
+func (UnauthenticatedError) Code() Code {
+	return CodeUnauthenticated
+}
+
+type RateLimitedError struct {
+	Details string
+}
+
+func (err RateLimitedError) Error() string {
+	return err.Details
+}
+
+func (RateLimitedError) Code() Code {
+	return CodeRateLimited
+}
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"context"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/utils/http_utils"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	StatusUp   = "up"
+	StatusDown = "down"
+)
+
+// Check pings a dependency, e.g. database client
+type Check func(ctx context.Context) error
+
+// DependencyStatus is sent to anyone who can reach readiness probe,
+// so Error of the driver (hosts, users, schema details) is only logged
+type DependencyStatus struct {
+	Status    string  `json:"status"`
+	LatencyMs float64 `json:"latencyMs"`
+	Error     string  `json:"-"`
+}
+
+// Report is readiness of the instance, it's ready when all dependencies are up
+type Report struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// Checker runs registered checks of dependencies, every check is limited by timeout
+type Checker struct {
+	timeout time.Duration
+	mu      sync.RWMutex
+	checks  map[string]Check
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	return &Checker{timeout: timeout, checks: make(map[string]Check)}
+}
+
+// Add registers check of the dependency, check of the same name is replaced
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+// Check runs all checks concurrently, so the slowest dependency bounds response time
+func (c *Checker) Check(ctx context.Context) *Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	report := &Report{Status: StatusUp, Dependencies: make(map[string]DependencyStatus, len(c.checks))}
+	mu := sync.Mutex{}
+	wg := sync.WaitGroup{}
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func(name string, check Check) {
+			defer wg.Done()
+			status := c.run(ctx, check)
+			mu.Lock()
+			defer mu.Unlock()
+			report.Dependencies[name] = status
+			if status.Status != StatusUp {
+				report.Status = StatusDown
+			}
+		}(name, check)
+	}
+	wg.Wait()
+	return report
+}
+
+func (c *Checker) run(ctx context.Context, check Check) DependencyStatus {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	started := time.Now()
+	err := check(ctx)
+	status := DependencyStatus{
+		Status:    StatusUp,
+		LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+	}
+	if err != nil {
+		status.Status = StatusDown
+		status.Error = err.Error()
+	}
+	return status
+}
+
+// Live reports that process is running and serves requests, dependencies aren't checked
+func (c *Checker) Live(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	http_utils.JsonResp(w, map[string]string{"status": StatusUp}, http.StatusOK)
+}
+
+// Ready reports status of every dependency, 503 means traffic should be routed to other instances
+func (c *Checker) Ready(w http.ResponseWriter, r *http.Request) {
+	report := c.Check(r.Context())
+	code := http.StatusOK
+	if report.Status != StatusUp {
+		code = http.StatusServiceUnavailable
+		for name, dep := range report.Dependencies {
+			if dep.Status != StatusUp {
+				log.Rlog(r).Warn("Not ready", log.Fields{"dependency": name, "error": dep.Error, "latencyMs": dep.LatencyMs})
+			}
+		}
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	http_utils.JsonResp(w, report, code)
+}
@@ -0,0 +1,98 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestChecker_Ready(t *testing.T) {
+	for _, tt := range [...]struct {
+		name     string
+		checks   map[string]Check
+		code     int
+		status   string
+		statuses map[string]string
+	}{
+		{
+			name:     "all up",
+			checks:   map[string]Check{"postgres": up, "redis": up},
+			code:     http.StatusOK,
+			status:   StatusUp,
+			statuses: map[string]string{"postgres": StatusUp, "redis": StatusUp},
+		},
+		{
+			name:     "one failed",
+			checks:   map[string]Check{"postgres": up, "redis": failed},
+			code:     http.StatusServiceUnavailable,
+			status:   StatusDown,
+			statuses: map[string]string{"postgres": StatusUp, "redis": StatusDown},
+		},
+		{
+			name:     "one hangs",
+			checks:   map[string]Check{"mongo": hangs, "redis": up},
+			code:     http.StatusServiceUnavailable,
+			status:   StatusDown,
+			statuses: map[string]string{"mongo": StatusDown, "redis": StatusUp},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := NewChecker(50 * time.Millisecond)
+			for name, check := range tt.checks {
+				checker.Add(name, check)
+			}
+
+			w := httptest.NewRecorder()
+			checker.Ready(w, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			assert.Equal(t, tt.code, w.Code)
+			assert.NotContains(t, w.Body.String(), "connection refused", "driver errors are only logged")
+
+			report := &Report{}
+			require.NoError(t, json.Unmarshal(w.Body.Bytes(), report))
+			assert.Equal(t, tt.status, report.Status)
+			statuses := make(map[string]string, len(report.Dependencies))
+			for name, dep := range report.Dependencies {
+				statuses[name] = dep.Status
+			}
+			assert.Equal(t, tt.statuses, statuses)
+		})
+	}
+}
+
+func TestChecker_Check(t *testing.T) {
+	checker := NewChecker(50 * time.Millisecond)
+	checker.Add("redis", failed)
+
+	report := checker.Check(context.Background())
+	assert.Equal(t, StatusDown, report.Dependencies["redis"].Status)
+	assert.Equal(t, "connection refused", report.Dependencies["redis"].Error)
+}
+
+func TestChecker_Live(t *testing.T) {
+	checker := NewChecker(time.Second)
+	checker.Add("postgres", failed)
+
+	w := httptest.NewRecorder()
+	checker.Live(w, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, w.Code, "liveness doesn't depend on datastores")
+	assert.JSONEq(t, `{"status": "up"}`, w.Body.String())
+}
+
+func up(ctx context.Context) error {
+	return nil
+}
+
+func failed(ctx context.Context) error {
+	return errors.New("connection refused")
+}
+
+func hangs(ctx context.Context) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
@@ -2,6 +2,7 @@ package log
 
 import (
 	"context"
+	"fmt"
 	"github.com/sirupsen/logrus"
 	"net/http"
 )
@@ -22,20 +23,25 @@ type ILogger interface {
 	SetContext(Fields)
 }
 
-var std = logrus.New()
+const (
+	FormatText = "text"
+	FormatJson = "json"
+)
+
+var std = newStd()
 var defaultLogger = New()
 
 func Debug(msg string, fields ...Fields) {
-	defaultLogger.Debug(msg, fields...)
+	defaultLogger.write(DebugLevel, msg, fields, 2)
 }
 func Info(msg string, fields ...Fields) {
-	defaultLogger.Info(msg, fields...)
+	defaultLogger.write(InfoLevel, msg, fields, 2)
 }
 func Warn(msg string, fields ...Fields) {
-	defaultLogger.Warn(msg, fields...)
+	defaultLogger.write(WarnLevel, msg, fields, 2)
 }
 func Error(msg string, fields ...Fields) {
-	defaultLogger.Error(msg, fields...)
+	defaultLogger.write(errorLevel, msg, fields, 2)
 }
 
 type Logger struct {
@@ -57,19 +63,41 @@ func (log *Logger) SetContext(fields Fields) {
 }
 
 func (log *Logger) Debug(msg string, fields ...Fields) {
-	log.withFields(fields...).Debug(msg)
+	log.write(DebugLevel, msg, fields, 2)
 }
 
 func (log *Logger) Info(msg string, fields ...Fields) {
-	log.withFields(fields...).Info(msg)
+	log.write(InfoLevel, msg, fields, 2)
 }
 
 func (log *Logger) Warn(msg string, fields ...Fields) {
-	log.withFields(fields...).Warn(msg)
+	log.write(WarnLevel, msg, fields, 2)
 }
 
 func (log *Logger) Error(msg string, fields ...Fields) {
-	log.withFields(fields...).Error(msg)
+	log.write(errorLevel, msg, fields, 2)
+}
+
+// write logs entry allowed by package rules, skip is the number of frames above write to the call site
+func (log *Logger) write(level int, msg string, fields []Fields, skip int) {
+	ok, sampled := current.Load().(*rules).allow(level, msg, skip+1)
+	if !ok {
+		return
+	}
+	entry := log.withFields(fields...)
+	if sampled > 1 {
+		entry = entry.WithField("sampled", fmt.Sprintf("1/%d", sampled))
+	}
+	switch level {
+	case DebugLevel:
+		entry.Debug(msg)
+	case InfoLevel:
+		entry.Info(msg)
+	case WarnLevel:
+		entry.Warn(msg)
+	default:
+		entry.Error(msg)
+	}
 }
 
 func (log *Logger) withFields(fields ...Fields) *logrus.Entry {
@@ -79,8 +107,33 @@ func (log *Logger) withFields(fields ...Fields) *logrus.Entry {
 	return log.logger.WithFields(logrus.Fields(fields[0]))
 }
 
-func SetupLogger(level int) {
-	switch level {
+// Options of logging: format is "text" or "json", levels override level of packages
+// and debug entries of sampled packages are written once per N entries with the same message.
+// Packages are named by import path inside the module, like "session/repo".
+type Options struct {
+	Level    int
+	Format   string
+	Levels   map[string]string
+	Sampling map[string]int
+}
+
+func SetupLogger(opts Options) error {
+	r, err := newRules(opts.Level, opts.Levels, opts.Sampling)
+	if err != nil {
+		return err
+	}
+	switch opts.Format {
+	case FormatText, "":
+		std.SetFormatter(&logrus.TextFormatter{
+			FullTimestamp: true,
+		})
+	case FormatJson:
+		std.SetFormatter(&logrus.JSONFormatter{})
+	default:
+		return fmt.Errorf("unknown log format %q", opts.Format)
+	}
+	// logrus lets through everything some package needs, rules filter the rest
+	switch r.maxLevel() {
 	case WarnLevel:
 		std.SetLevel(logrus.WarnLevel)
 	case InfoLevel:
@@ -88,12 +141,17 @@ func SetupLogger(level int) {
 	case DebugLevel:
 		std.SetLevel(logrus.DebugLevel)
 	}
-	std.SetFormatter(&logrus.TextFormatter{
-		FullTimestamp: true,
-	})
+	current.Store(r)
 	//std.SetReportCaller(true)
 
-	Debug("Logger configured", Fields{"level": level})
+	Debug("Logger configured", Fields{"level": opts.Level, "format": opts.Format})
+	return nil
+}
+
+func newStd() *logrus.Logger {
+	logger := logrus.New()
+	logger.AddHook(redactHook{})
+	return logger
 }
 
 func Rlog(r *http.Request) ILogger {
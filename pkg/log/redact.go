@@ -0,0 +1,95 @@
+package log
+
+import (
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+const Redacted = "[REDACTED]"
+
+// secretKeys are parts of field names and headers which values are never logged
+var secretKeys = []string{"token", "password", "pass_hash", "passwd", "authorization", "secret", "cookie", "jwt"}
+
+var (
+	bearerValue = regexp.MustCompile(`(?i)\bbearer\s+\S+`)
+	jwtValue    = regexp.MustCompile(`\beyJ[\w-]+\.[\w-]+\.[\w-]+`)
+)
+
+// redactHook masks secrets in fields of every entry before it's formatted,
+// so secrets are masked whatever logger or call site they come from
+type redactHook struct{}
+
+func (redactHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (redactHook) Fire(entry *logrus.Entry) error {
+	// entry data is a copy made for this entry, it's safe to change it
+	for key, value := range entry.Data {
+		entry.Data[key] = redactField(key, value)
+	}
+	entry.Message = redactString(entry.Message)
+	return nil
+}
+
+// Redact returns copy of fields with secrets masked
+func Redact(fields Fields) Fields {
+	out := make(Fields, len(fields))
+	for key, value := range fields {
+		out[key] = redactField(key, value)
+	}
+	return out
+}
+
+func redactField(key string, value interface{}) interface{} {
+	if isSecretKey(key) {
+		return Redacted
+	}
+	switch v := value.(type) {
+	case string:
+		return redactString(v)
+	case error:
+		return redactString(v.Error())
+	case http.Header:
+		return redactHeader(v)
+	case Fields:
+		return Redact(v)
+	case map[string]interface{}:
+		return map[string]interface{}(Redact(v))
+	}
+	return value
+}
+
+func redactHeader(header http.Header) http.Header {
+	out := make(http.Header, len(header))
+	for key, values := range header {
+		if isSecretKey(key) {
+			out[key] = []string{Redacted}
+			continue
+		}
+		masked := make([]string, 0, len(values))
+		for _, value := range values {
+			masked = append(masked, redactString(value))
+		}
+		out[key] = masked
+	}
+	return out
+}
+
+// redactString masks bearer credentials and JWTs inside free text, e.g. error messages
+func redactString(s string) string {
+	s = bearerValue.ReplaceAllString(s, "Bearer "+Redacted)
+	return jwtValue.ReplaceAllString(s, Redacted)
+}
+
+func isSecretKey(key string) bool {
+	key = strings.ToLower(key)
+	for _, secret := range secretKeys {
+		if strings.Contains(key, secret) {
+			return true
+		}
+	}
+	return false
+}
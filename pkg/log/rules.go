@@ -0,0 +1,147 @@
+package log
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// modulePrefixes are cut from import path of the caller, so packages are named like "session/repo"
+var modulePrefixes = []string{"golang-stepik-2022q1/reditclone/pkg/", "golang-stepik-2022q1/reditclone/"}
+
+// errorLevel is level of Error entries, they are never filtered out
+const errorLevel = WarnLevel - 1
+
+// rules are per package levels and debug sampling, package rule also covers its subpackages
+type rules struct {
+	level    int
+	levels   map[string]int
+	sampling map[string]uint64
+	// callers caches package of call site by program counter
+	callers sync.Map
+	// counters are numbers of sampled debug entries by package rule and message
+	counters sync.Map
+}
+
+var current atomic.Value
+
+func init() {
+	current.Store(&rules{level: InfoLevel})
+}
+
+func newRules(level int, levels map[string]string, sampling map[string]int) (*rules, error) {
+	r := &rules{level: level, levels: make(map[string]int, len(levels)), sampling: make(map[string]uint64, len(sampling))}
+	for pkg, name := range levels {
+		lvl, err := ParseLevel(name)
+		if err != nil {
+			return nil, fmt.Errorf("level of %s: %w", pkg, err)
+		}
+		r.levels[pkg] = lvl
+	}
+	for pkg, every := range sampling {
+		if every < 1 {
+			return nil, fmt.Errorf("sampling of %s should be positive, got %d", pkg, every)
+		}
+		r.sampling[pkg] = uint64(every)
+	}
+	return r, nil
+}
+
+// ParseLevel parses level name: "warn", "info" or "debug"
+func ParseLevel(name string) (int, error) {
+	switch strings.ToLower(name) {
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "info":
+		return InfoLevel, nil
+	case "debug":
+		return DebugLevel, nil
+	}
+	return 0, fmt.Errorf("unknown log level %q", name)
+}
+
+// maxLevel is the most verbose level of rules, logrus has to let such entries through
+func (r *rules) maxLevel() int {
+	max := r.level
+	for _, lvl := range r.levels {
+		if lvl > max {
+			max = lvl
+		}
+	}
+	return max
+}
+
+// allow reports whether entry of the level should be written,
+// sampled is N when only one of N entries with the same message is written.
+// skip is the number of frames above allow to the call site.
+func (r *rules) allow(level int, msg string, skip int) (ok bool, sampled uint64) {
+	if level == errorLevel {
+		return true, 0
+	}
+	if len(r.levels) == 0 && len(r.sampling) == 0 {
+		return level <= r.level, 0
+	}
+
+	pkg := r.caller(skip + 1)
+	threshold := r.level
+	if rule := matchRule(pkg, r.levels); rule != "" {
+		threshold = r.levels[rule]
+	}
+	if level > threshold {
+		return false, 0
+	}
+	if level != DebugLevel {
+		return true, 0
+	}
+	rule := matchRule(pkg, r.sampling)
+	if rule == "" {
+		return true, 0
+	}
+	every := r.sampling[rule]
+	counter, _ := r.counters.LoadOrStore(rule+"\x00"+msg, new(uint64))
+	n := atomic.AddUint64(counter.(*uint64), 1)
+	return (n-1)%every == 0, every
+}
+
+// caller returns package of the function skip frames above
+func (r *rules) caller(skip int) string {
+	pcs := [1]uintptr{}
+	if runtime.Callers(skip+1, pcs[:]) == 0 {
+		return ""
+	}
+	if pkg, ok := r.callers.Load(pcs[0]); ok {
+		return pkg.(string)
+	}
+	// frames resolve inlined calls, so small callers are still named right
+	frame, _ := runtime.CallersFrames(pcs[:]).Next()
+	pkg := packageOf(frame.Function)
+	r.callers.Store(pcs[0], pkg)
+	return pkg
+}
+
+// packageOf cuts package from function name like "module/pkg/session/repo.(*RedisRepo).Set"
+func packageOf(funcName string) string {
+	slash := strings.LastIndex(funcName, "/")
+	if dot := strings.Index(funcName[slash+1:], "."); dot >= 0 {
+		funcName = funcName[:slash+1+dot]
+	}
+	for _, prefix := range modulePrefixes {
+		if strings.HasPrefix(funcName, prefix) {
+			return strings.TrimPrefix(funcName, prefix)
+		}
+	}
+	return funcName
+}
+
+// matchRule returns the longest rule covering the package
+func matchRule[T any](pkg string, rules map[string]T) string {
+	best := ""
+	for rule := range rules {
+		if (pkg == rule || strings.HasPrefix(pkg, rule+"/")) && len(rule) > len(best) {
+			best = rule
+		}
+	}
+	return best
+}
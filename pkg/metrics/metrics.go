@@ -0,0 +1,92 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const namespace = "reddit"
+
+// datastores of repo operations
+const (
+	Mongo = "mongo"
+	Sql   = "sql"
+	Redis = "redis"
+)
+
+var (
+	httpRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route template, method and status code.",
+	}, []string{"route", "method", "status"})
+	httpDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by route template, method and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method", "status"})
+
+	repoDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "repo_operation_duration_seconds",
+		Help:      "Repo operation latency by datastore and operation.",
+		Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5},
+	}, []string{"store", "op"})
+
+	PostsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "posts_created_total",
+		Help:      "Posts created.",
+	})
+	CommentsCreated = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "comments_created_total",
+		Help:      "Comments created.",
+	})
+	// Votes are labelled by target ("post" or "comment") and vote ("up", "down" or "un")
+	Votes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "votes_total",
+		Help:      "Votes recorded by target and vote.",
+	}, []string{"target", "vote"})
+	Logins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "logins_total",
+		Help:      "Successful logins.",
+	})
+	FailedLogins = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "failed_logins_total",
+		Help:      "Logins failed because of unknown user or wrong password.",
+	})
+	SessionsIssued = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "sessions_issued_total",
+		Help:      "Sessions issued on login and registration.",
+	})
+)
+
+// Handler exposes metrics in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// ObserveRequest records handled request, route is a template like "/api/post/{postId}"
+// to keep the number of label values bounded
+func ObserveRequest(route, method string, status int, took time.Duration) {
+	code := strconv.Itoa(status)
+	httpRequests.WithLabelValues(route, method, code).Inc()
+	httpDuration.WithLabelValues(route, method, code).Observe(took.Seconds())
+}
+
+// ObserveRepo records repo operation started at started, it's meant to be deferred:
+//
+//	defer metrics.ObserveRepo(metrics.Mongo, "posts.GetById", time.Now())
+func ObserveRepo(store, op string, started time.Time) {
+	repoDuration.WithLabelValues(store, op).Observe(time.Since(started).Seconds())
+}
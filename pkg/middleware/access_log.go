@@ -0,0 +1,154 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/session"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// access log formats: entry of application log, Apache combined log line or JSON line
+const (
+	AccessLogApp      = "log"
+	AccessLogCombined = "combined"
+	AccessLogJson     = "json"
+)
+
+const accessRecordKey = "accessRecord"
+
+// accessRecord is filled by handlers down the chain, e.g. authentication sets user of the request
+type accessRecord struct {
+	userId int
+}
+
+type accessEntry struct {
+	Time       time.Time `json:"time"`
+	RequestID  string    `json:"request_id"`
+	RemoteAddr string    `json:"remote_addr"`
+	Method     string    `json:"method"`
+	Url        string    `json:"url"`
+	Route      string    `json:"route"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	UserId     int       `json:"user_id,omitempty"`
+	UserAgent  string    `json:"user_agent"`
+	Referer    string    `json:"referer,omitempty"`
+	WorkTime   float64   `json:"work_time_ms"`
+
+	took time.Duration
+}
+
+type AccessLogOptions struct {
+	Format string
+	// Out receives combined and JSON lines, application log entries go to the log
+	Out io.Writer
+	// Requests longer than SlowThreshold are logged at Warn as well, zero disables it
+	SlowThreshold time.Duration
+}
+
+// AccessLog logs every request with its status, response size, route template and user
+func AccessLog(opts AccessLogOptions) (func(http.Handler) http.Handler, error) {
+	switch opts.Format {
+	case AccessLogApp, AccessLogCombined, AccessLogJson:
+	default:
+		return nil, fmt.Errorf("unknown access log format %q", opts.Format)
+	}
+	// lines of concurrent requests must not interleave
+	mu := &sync.Mutex{}
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			record := &accessRecord{}
+			sw := &statusWriter{ResponseWriter: w}
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), accessRecordKey, record)))
+
+			entry := &accessEntry{
+				Time:       start,
+				RequestID:  RequestIDFromContext(r.Context()),
+				RemoteAddr: r.RemoteAddr,
+				Method:     r.Method,
+				Url:        r.URL.RequestURI(),
+				Route:      RouteTemplate(r),
+				Status:     sw.Status(),
+				Bytes:      sw.bytes,
+				UserId:     record.userId,
+				UserAgent:  r.UserAgent(),
+				Referer:    r.Referer(),
+				took:       time.Since(start),
+			}
+			entry.WorkTime = float64(entry.took.Microseconds()) / 1000
+
+			switch opts.Format {
+			case AccessLogCombined:
+				mu.Lock()
+				fmt.Fprintln(opts.Out, entry.Combined(r.Proto))
+				mu.Unlock()
+			case AccessLogJson:
+				line, _ := json.Marshal(entry)
+				mu.Lock()
+				opts.Out.Write(append(line, '\n'))
+				mu.Unlock()
+			default:
+				log.Rlog(r).Info(r.URL.Path, entry.Fields())
+			}
+			if opts.SlowThreshold > 0 && entry.took > opts.SlowThreshold {
+				log.Rlog(r).Warn("Slow request", entry.Fields())
+			}
+		})
+	}, nil
+}
+
+// Combined formats entry as Apache combined log line, user is the user id
+func (e *accessEntry) Combined(proto string) string {
+	host, _, err := net.SplitHostPort(e.RemoteAddr)
+	if err != nil {
+		host = e.RemoteAddr
+	}
+	user := "-"
+	if e.UserId != 0 {
+		user = strconv.Itoa(e.UserId)
+	}
+	size := "-"
+	if e.Bytes > 0 {
+		size = strconv.Itoa(e.Bytes)
+	}
+	return fmt.Sprintf(`%s - %s [%s] "%s %s %s" %d %s %q %q`,
+		host, user, e.Time.Format("02/Jan/2006:15:04:05 -0700"),
+		e.Method, e.Url, proto, e.Status, size, orDash(e.Referer), orDash(e.UserAgent),
+	)
+}
+
+func (e *accessEntry) Fields() log.Fields {
+	return log.Fields{
+		"method":      e.Method,
+		"remote_addr": e.RemoteAddr,
+		"url":         e.Url,
+		"route":       e.Route,
+		"status":      e.Status,
+		"bytes":       e.Bytes,
+		"user_id":     e.UserId,
+		"user_agent":  e.UserAgent,
+		"work_time":   e.took,
+	}
+}
+
+// recordUser tells access log who made the request
+func recordUser(ctx context.Context, sess *session.Session) {
+	if record, ok := ctx.Value(accessRecordKey).(*accessRecord); ok && sess != nil {
+		record.userId = sess.User.Id
+	}
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang-stepik-2022q1/reditclone/pkg/session"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+func accessLogRouter(t *testing.T, format string, out *bytes.Buffer) *mux.Router {
+	accessLog, err := AccessLog(AccessLogOptions{Format: format, Out: out})
+	require.NoError(t, err)
+
+	router := mux.NewRouter()
+	router.HandleFunc("/api/post/{postId}", func(w http.ResponseWriter, r *http.Request) {
+		recordUser(r.Context(), &session.Session{User: session.UserClaims{Id: 42, Username: "john"}})
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"id":"1"}`))
+	})
+	router.Use(SetupReqID, accessLog)
+	return router
+}
+
+func TestAccessLog_Combined(t *testing.T) {
+	out := &bytes.Buffer{}
+	router := accessLogRouter(t, AccessLogCombined, out)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/post/1?sort=new", nil)
+	req.Header.Set("User-Agent", "curl/7.68.0")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	expected := regexp.MustCompile(`^192\.0\.2\.1 - 42 \[.+\] "POST /api/post/1\?sort=new HTTP/1\.1" 201 10 "-" "curl/7\.68\.0"\n$`)
+	assert.Regexp(t, expected, out.String())
+}
+
+func TestAccessLog_Json(t *testing.T) {
+	out := &bytes.Buffer{}
+	router := accessLogRouter(t, AccessLogJson, out)
+
+	req := httptest.NewRequest(http.MethodPost, "/api/post/1", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	entry := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(out.Bytes(), &entry))
+	assert.Equal(t, "abc", entry["request_id"])
+	assert.Equal(t, "/api/post/{postId}", entry["route"])
+	assert.Equal(t, float64(http.StatusCreated), entry["status"])
+	assert.Equal(t, float64(10), entry["bytes"])
+	assert.Equal(t, float64(42), entry["user_id"])
+}
+
+func TestAccessLog_UnknownFormat(t *testing.T) {
+	_, err := AccessLog(AccessLogOptions{Format: "xml"})
+	assert.Error(t, err)
+}
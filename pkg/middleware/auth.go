@@ -2,9 +2,11 @@ package middleware
 
 import (
 	"context"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
 	"golang-stepik-2022q1/reditclone/pkg/session"
 	sessionUC "golang-stepik-2022q1/reditclone/pkg/session/usecase"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
 	"golang-stepik-2022q1/reditclone/pkg/utils/http_utils"
 	"net/http"
 	"strings"
@@ -12,32 +14,68 @@ import (
 
 const AuthHeader = "Authorization"
 
+var (
+	noTokenErr    = errors.UnauthenticatedError{Details: "No token provided"}
+	wrongTokenErr = errors.UnauthenticatedError{Details: "Wrong token"}
+)
+
 func Authentication(sm *sessionUC.Manager) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
-			token := r.Header.Get(AuthHeader)
-			if token == "" {
+			sess, err := authenticate(ctx, sm, r)
+			if err == noTokenErr {
 				log.Clog(ctx).Info("Authorization failed. No token provided")
-				http_utils.HttpError(w, "Authorization failed", http.StatusUnauthorized)
+				http_utils.HttpError(w, errors.UnauthenticatedError{Details: "Authorization failed"})
 				return
 			}
-			parts := strings.Fields(token)
-			if len(parts) != 2 {
-				log.Clog(ctx).Info("Authorization failed. Wrong token", log.Fields{"token": token})
-				http_utils.HttpError(w, "Authorization failed", http.StatusUnauthorized)
+			if err == wrongTokenErr {
+				log.Clog(ctx).Info("Authorization failed. Wrong token")
+				http_utils.HttpError(w, errors.UnauthenticatedError{Details: "Authorization failed"})
 				return
 			}
-			token = parts[1]
-			sess, err := sm.Check(ctx, token)
 			if err != nil {
-				log.Clog(ctx).Info("Authorization failed", log.Fields{"error": err.Error(), "token": token})
-				http_utils.HttpError(w, err.Error(), http.StatusUnauthorized)
+				log.Clog(ctx).Info("Authorization failed", log.Fields{"error": err.Error()})
+				http_utils.HttpError(w, err)
 				return
 			}
 
+			recordUser(ctx, sess)
 			ctx = context.WithValue(ctx, session.SessionKey, sess)
 			next.ServeHTTP(w, r.WithContext(ctx))
 		})
 	}
 }
+
+// OptionalAuthentication puts session to request context if valid token provided,
+// but lets anonymous requests through
+func OptionalAuthentication(sm *sessionUC.Manager) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			sess, err := authenticate(ctx, sm, r)
+			if err == nil {
+				recordUser(ctx, sess)
+				ctx = context.WithValue(ctx, session.SessionKey, sess)
+			} else if err != noTokenErr {
+				log.Clog(ctx).Debug("Optional authorization failed", log.Fields{"error": err.Error()})
+			}
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// authenticate checks bearer token of the request, the token is a credential and is never logged
+func authenticate(ctx context.Context, sm *sessionUC.Manager, r *http.Request) (_ *session.Session, err error) {
+	ctx, span := tracing.Start(ctx, "middleware.authenticate")
+	defer tracing.End(span, &err)
+	token := r.Header.Get(AuthHeader)
+	if token == "" {
+		return nil, noTokenErr
+	}
+	parts := strings.Fields(token)
+	if len(parts) != 2 {
+		return nil, wrongTokenErr
+	}
+	return sm.Check(ctx, parts[1])
+}
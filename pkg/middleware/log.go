@@ -4,9 +4,9 @@ import (
 	"context"
 	"fmt"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
 	"math/rand"
 	"net/http"
-	"time"
 )
 
 const RequestIDKey = "requestID"
@@ -15,8 +15,12 @@ func SetupReqID(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		requestID := r.Header.Get("X-Request-ID")
 		if requestID == "" {
-			// https://github.com/opentracing/specification/blob/master/rfc/trace_identifiers.md
-			requestID = RandBytesHex(16)
+			// id of the trace started by Tracing middleware, so log lines can be found by trace
+			requestID = tracing.TraceId(r.Context())
+			if requestID == "" {
+				// https://github.com/opentracing/specification/blob/master/rfc/trace_identifiers.md
+				requestID = RandBytesHex(16)
+			}
 			r.Header.Set("X-Request-ID", requestID)
 			r.Header.Set("trace-id", requestID)
 			w.Header().Set("trace-id", requestID)
@@ -40,23 +44,6 @@ func InjectLogger(next http.Handler) http.Handler {
 	})
 }
 
-func SetupAccessLog(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
-		next.ServeHTTP(w, r)
-
-		log.Rlog(r).Info(
-			r.URL.Path,
-			log.Fields{
-				"method":      r.Method,
-				"remote_addr": r.RemoteAddr,
-				"url":         r.URL.Path,
-				"work_time":   time.Since(start),
-			},
-		)
-	})
-}
-
 func RandBytesHex(n int) string {
 	return fmt.Sprintf("%x", RandBytes(n))
 }
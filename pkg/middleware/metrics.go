@@ -0,0 +1,63 @@
+package middleware
+
+import (
+	"context"
+	"github.com/gorilla/mux"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
+	"net/http"
+	"time"
+)
+
+// unmatchedRoute labels requests without mux route, so raw paths never become label values
+const unmatchedRoute = "unmatched"
+
+// Metrics counts requests and their latency by route template and status code
+func Metrics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		metrics.ObserveRequest(RouteTemplate(r), r.Method, sw.Status(), time.Since(start))
+	})
+}
+
+const routeRecordKey = "routeRecord"
+
+// routeRecord passes template of the route matched by API router (see RecordRoute)
+// to middleware wrapping the whole site, like Tracing and Recovery
+type routeRecord struct {
+	route string
+}
+
+// withRouteRecord returns request carrying route record and the record,
+// record of outer middleware is shared, so all of them get the route
+func withRouteRecord(r *http.Request) (*http.Request, *routeRecord) {
+	if record, ok := r.Context().Value(routeRecordKey).(*routeRecord); ok {
+		return r, record
+	}
+	record := &routeRecord{route: RouteTemplate(r)}
+	return r.WithContext(context.WithValue(r.Context(), routeRecordKey, record)), record
+}
+
+// RecordRoute makes matched route known to middleware wrapping the router, it's used by the router
+func RecordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if record, ok := r.Context().Value(routeRecordKey).(*routeRecord); ok {
+			record.route = RouteTemplate(r)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RouteTemplate returns template of matched mux route, like "/api/post/{postId}"
+func RouteTemplate(r *http.Request) string {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return unmatchedRoute
+	}
+	tpl, err := route.GetPathTemplate()
+	if err != nil {
+		return unmatchedRoute
+	}
+	return tpl
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_LabelsByRouteTemplate(t *testing.T) {
+	router := mux.NewRouter()
+	router.HandleFunc("/api/post/{postId}", func(w http.ResponseWriter, r *http.Request) {
+		if mux.Vars(r)["postId"] == "missing" {
+			http.Error(w, "not found", http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("{}"))
+	})
+	router.Use(Metrics)
+
+	for _, path := range []string{"/api/post/1", "/api/post/2", "/api/post/missing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	expected := `
+		# HELP reddit_http_requests_total HTTP requests by route template, method and status code.
+		# TYPE reddit_http_requests_total counter
+		reddit_http_requests_total{method="GET",route="/api/post/{postId}",status="200"} 2
+		reddit_http_requests_total{method="GET",route="/api/post/{postId}",status="404"} 1
+	`
+	err := testutil.GatherAndCompare(prometheus.DefaultGatherer, strings.NewReader(expected), "reddit_http_requests_total")
+	assert.NoError(t, err)
+}
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// RealIP replaces RemoteAddr of the request with address of the client when request comes through trusted proxy.
+// Client is the rightmost untrusted address of X-Forwarded-For (left ones can be forged by the client),
+// or X-Real-IP when there is no X-Forwarded-For. Headers of requests from other addresses are ignored.
+// Trusted proxies are IPs or CIDRs, like "10.0.0.0/8", none are trusted by default.
+func RealIP(trusted []string) (func(http.Handler) http.Handler, error) {
+	nets := make([]*net.IPNet, 0, len(trusted))
+	for _, proxy := range trusted {
+		if !strings.Contains(proxy, "/") {
+			if ip := net.ParseIP(proxy); ip != nil && ip.To4() != nil {
+				proxy += "/32"
+			} else {
+				proxy += "/128"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	isTrusted := func(addr string) bool {
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return false
+		}
+		for _, ipNet := range nets {
+			if ipNet.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			host, _, err := net.SplitHostPort(r.RemoteAddr)
+			if err != nil {
+				host = r.RemoteAddr
+			}
+			if !isTrusted(host) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			client := ""
+			if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+				hops := strings.Split(strings.Join(forwarded, ","), ",")
+				for idx := len(hops) - 1; idx >= 0; idx-- {
+					client = strings.TrimSpace(hops[idx])
+					if !isTrusted(client) {
+						break
+					}
+				}
+			} else {
+				client = strings.TrimSpace(r.Header.Get("X-Real-IP"))
+			}
+			if net.ParseIP(client) != nil {
+				r = r.WithContext(r.Context())
+				r.RemoteAddr = client
+			}
+			next.ServeHTTP(w, r)
+		})
+	}, nil
+}
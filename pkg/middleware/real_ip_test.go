@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRealIP(t *testing.T) {
+	realIP, err := RealIP([]string{"10.0.0.0/8", "192.0.2.1"})
+	require.NoError(t, err)
+	var remoteAddr string
+	handler := realIP(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		remoteAddr = r.RemoteAddr
+	}))
+
+	for _, tt := range [...]struct {
+		name       string
+		remoteAddr string
+		headers    map[string]string
+		expected   string
+	}{
+		{"No headers", "192.0.2.1:1234", nil, "192.0.2.1:1234"},
+		{"Untrusted remote", "203.0.113.7:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "203.0.113.7:1234"},
+		{"Forwarded", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "198.51.100.1"}, "198.51.100.1"},
+		{"Forged hop", "192.0.2.1:1234", map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.1, 10.1.1.1"}, "198.51.100.1"},
+		{"Real IP", "10.1.1.1:1234", map[string]string{"X-Real-IP": "198.51.100.1"}, "198.51.100.1"},
+		{"Malformed", "10.1.1.1:1234", map[string]string{"X-Forwarded-For": "unknown"}, "10.1.1.1:1234"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/api/post/1", nil)
+			req.RemoteAddr = tt.remoteAddr
+			for name, value := range tt.headers {
+				req.Header.Set(name, value)
+			}
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			assert.Equal(t, tt.expected, remoteAddr)
+		})
+	}
+}
+
+func TestRealIP_InvalidProxy(t *testing.T) {
+	_, err := RealIP([]string{"proxy.local"})
+	assert.Error(t, err)
+}
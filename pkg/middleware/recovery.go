@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"fmt"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/reporting"
+	"golang-stepik-2022q1/reditclone/pkg/utils/http_utils"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Recovery turns panic of a handler into JSON 500 response, logs it with the stack
+// and hands it to the reporter. It has to be placed after SetupReqID and InjectLogger,
+// so the log entry and the report carry id of the request, and before everything else,
+// so panics of other middleware are recovered too.
+func Recovery(reporter reporting.Reporter) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			sw := &statusWriter{ResponseWriter: w}
+			r, record := withRouteRecord(r)
+			defer func() {
+				rec := recover()
+				if rec == nil {
+					return
+				}
+				if rec == http.ErrAbortHandler {
+					// server aborts the response on purpose, it's not an error
+					panic(rec)
+				}
+				report := &reporting.Report{
+					Time:      time.Now(),
+					RequestID: RequestIDFromContext(r.Context()),
+					Method:    r.Method,
+					Url:       r.URL.RequestURI(),
+					Route:     record.route,
+					Panic:     fmt.Sprint(rec),
+					Stack:     string(debug.Stack()),
+				}
+				log.Rlog(r).Error("Panic recovered", log.Fields{
+					"panic": report.Panic,
+					"route": report.Route,
+					"stack": report.Stack,
+				})
+				if err := reporter.Report(r.Context(), report); err != nil {
+					log.Rlog(r).Error("Cant report panic", log.Fields{"error": err.Error()})
+				}
+				// status line is already sent when handler panics in the middle of the response
+				if sw.status == 0 {
+					http_utils.HttpError(sw, errors.InternalError{Details: report.Panic})
+				}
+			}()
+			next.ServeHTTP(sw, r)
+		})
+	}
+}
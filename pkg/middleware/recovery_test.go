@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang-stepik-2022q1/reditclone/pkg/reporting"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type reportsRecorder struct {
+	reports []*reporting.Report
+}
+
+func (r *reportsRecorder) Report(_ context.Context, report *reporting.Report) error {
+	r.reports = append(r.reports, report)
+	return nil
+}
+
+func TestRecovery(t *testing.T) {
+	reporter := &reportsRecorder{}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/post/{id}", func(w http.ResponseWriter, r *http.Request) {
+		var post *struct{ Title string }
+		w.Write([]byte(post.Title))
+	})
+	router.Use(SetupReqID, InjectLogger, Recovery(reporter))
+
+	req := httptest.NewRequest(http.MethodGet, "/api/post/1", nil)
+	req.Header.Set("X-Request-ID", "abc")
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, req)
+
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	body := map[string]string{}
+	require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+	assert.Equal(t, "Internal error", body["message"])
+
+	require.Len(t, reporter.reports, 1)
+	report := reporter.reports[0]
+	assert.Equal(t, "abc", report.RequestID)
+	assert.Equal(t, "/api/post/{id}", report.Route)
+	assert.Contains(t, report.Panic, "nil pointer dereference")
+	assert.Contains(t, report.Stack, "TestRecovery")
+}
+
+func TestRecovery_ResponseStarted(t *testing.T) {
+	handler := Recovery(reporting.Nop{})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		panic("failed in the middle")
+	}))
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, "/api/posts/", nil))
+
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Empty(t, resp.Body.String())
+}
+
+// Recovery wraps the whole site, route is recorded by API router middleware after it
+func TestRecovery_WrapsSite(t *testing.T) {
+	reporter := &reportsRecorder{}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/post/{id}", func(w http.ResponseWriter, r *http.Request) {})
+	router.Use(RecordRoute, func(http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			panic("middleware failed")
+		})
+	})
+	site := http.NewServeMux()
+	site.Handle("/api/", router)
+	site.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		panic("probe failed")
+	})
+	handler := Tracing(SetupReqID(InjectLogger(Recovery(reporter)(site))))
+
+	for _, url := range []string{"/api/post/1", "/healthz"} {
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, httptest.NewRequest(http.MethodGet, url, nil))
+		assert.Equal(t, http.StatusInternalServerError, resp.Code, url)
+	}
+
+	require.Len(t, reporter.reports, 2)
+	assert.Equal(t, "/api/post/{id}", reporter.reports[0].Route)
+	assert.Equal(t, "middleware failed", reporter.reports[0].Panic)
+	assert.Equal(t, unmatchedRoute, reporter.reports[1].Route)
+	assert.Equal(t, "probe failed", reporter.reports[1].Panic)
+	assert.NotEqual(t, "-", reporter.reports[1].RequestID)
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"net/http"
+)
+
+// Tracing starts server span of the request covering the rest of middleware chain,
+// span continues the trace of incoming W3C traceparent header when it's present.
+// It's placed before everything else, so id of the request is the trace id (see SetupReqID).
+// Route is matched after the span is started, so the span is named by the route when request is served.
+func Tracing(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, record := withRouteRecord(r)
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracing.Tracer().Start(ctx, r.Method+" "+record.route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPMethodKey.String(r.Method),
+				semconv.HTTPTargetKey.String(r.URL.Path),
+				semconv.HTTPUserAgentKey.String(r.UserAgent()),
+			),
+		)
+		defer span.End()
+
+		sw := &statusWriter{ResponseWriter: w}
+		r = r.WithContext(ctx)
+		next.ServeHTTP(sw, r)
+
+		status := sw.Status()
+		span.SetName(r.Method + " " + record.route)
+		span.SetAttributes(
+			semconv.HTTPRouteKey.String(record.route),
+			semconv.HTTPStatusCodeKey.Int(status),
+			// set by SetupReqID down the chain
+			attribute.String("http.request_id", r.Header.Get("X-Request-ID")),
+		)
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"github.com/gorilla/mux"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracing_ContinuesIncomingTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	var requestId string
+	router := mux.NewRouter()
+	router.HandleFunc("/api/post/{postId}/upvote", func(w http.ResponseWriter, r *http.Request) {
+		requestId = RequestIDFromContext(r.Context())
+		_, span := tracing.Start(r.Context(), "posts.Manager.Upvote")
+		span.End()
+	})
+	router.Use(Tracing, SetupReqID)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/post/1/upvote", nil)
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	manager, server := spans[0], spans[1]
+	assert.Equal(t, "GET /api/post/{postId}/upvote", server.Name())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	assert.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	assert.Equal(t, server.SpanContext().SpanID(), manager.Parent().SpanID())
+	assert.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", requestId, "logs are joined with trace")
+}
+
+// server span wraps the whole site: it's named by route matched later by the router,
+// requests outside of the router are traced too, and request id is the trace id
+func TestTracing_WrapsSite(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	requestIds := make([]string, 0, 2)
+	saveId := func(w http.ResponseWriter, r *http.Request) {
+		requestIds = append(requestIds, RequestIDFromContext(r.Context()))
+	}
+	router := mux.NewRouter()
+	router.HandleFunc("/api/post/{postId}", saveId)
+	router.Use(RecordRoute)
+	site := http.NewServeMux()
+	site.Handle("/api/", router)
+	site.HandleFunc("/healthz", saveId)
+	handler := Tracing(SetupReqID(site))
+
+	for _, url := range []string{"/api/post/1", "/healthz"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, url, nil))
+	}
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	assert.Equal(t, "GET /api/post/{postId}", spans[0].Name())
+	assert.Equal(t, "GET "+unmatchedRoute, spans[1].Name())
+	for idx, span := range spans {
+		assert.Equal(t, span.SpanContext().TraceID().String(), requestIds[idx], "logs are joined with trace")
+	}
+}
@@ -0,0 +1,28 @@
+package posts
+
+import "golang-stepik-2022q1/reditclone/pkg/errors"
+
+// UnknownCategoryError means listing of missing category is requested
+var UnknownCategoryError = errors.NotFoundError{Resource: "Category"}
+
+// Categories is the registry of post categories offered by the frontend.
+var Categories = []string{
+	"music",
+	"funny",
+	"videos",
+	"programming",
+	"news",
+	"fashion",
+}
+
+var categorySet = func() map[string]bool {
+	set := make(map[string]bool, len(Categories))
+	for _, name := range Categories {
+		set[name] = true
+	}
+	return set
+}()
+
+func IsCategory(name string) bool {
+	return categorySet[name]
+}
@@ -3,9 +3,9 @@ package delivery
 import (
 	"encoding/json"
 	"github.com/gorilla/mux"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
 	"golang-stepik-2022q1/reditclone/pkg/posts"
-	"golang-stepik-2022q1/reditclone/pkg/posts/usecase"
 	"golang-stepik-2022q1/reditclone/pkg/session"
 	"golang-stepik-2022q1/reditclone/pkg/utils/http_utils"
 	"io/ioutil"
@@ -18,7 +18,7 @@ func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request) {
 	id, ok := vars["postId"]
 	if !ok {
 		log.Clog(ctx).Info("Improper request params", log.Fields{"id": id})
-		http_utils.HttpError(w, "Wrong id provided", http.StatusBadRequest)
+		http_utils.HttpError(w, errors.NewValidation("Wrong id provided", "postId", "is required"))
 		return
 	}
 
@@ -27,15 +27,15 @@ func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request) {
 	in := &posts.CommentIn{}
 	err := json.Unmarshal(body, in)
 	if err != nil {
-		log.Clog(ctx).Warn("Cant unmarshal comment", log.Fields{"err": err.Error()})
-		http_utils.HttpError(w, "Unmarshaling error", http.StatusInternalServerError)
+		log.Clog(ctx).Info("Cant unmarshal comment", log.Fields{"err": err.Error()})
+		http_utils.HttpError(w, &errors.ValidationError{Details: "Malformed request body: " + err.Error()})
 		return
 	}
 
 	sess := session.FromCtx(r.Context())
 	if sess == nil {
 		log.Rlog(r).Warn("Cant load session from request")
-		http_utils.HttpError(w, "Cant get creator info from request", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get creator info from request"})
 		return
 	}
 	in.Author.ID = sess.User.Id
@@ -43,12 +43,7 @@ func (h *Handler) AddComment(w http.ResponseWriter, r *http.Request) {
 
 	post, err := h.manager.CreateComment(ctx, id, in)
 	if err != nil {
-		if err == usecase.ItemNotFound {
-			http_utils.HttpError(w, "Post not found", http.StatusNotFound)
-			return
-		}
-		log.Clog(ctx).Error("Cant create comment", log.Fields{"error": err.Error()})
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 
@@ -63,17 +58,18 @@ func (h *Handler) DeleteComment(w http.ResponseWriter, r *http.Request) {
 
 	if postId == "" || commentId == "" {
 		log.Clog(ctx).Info("Improper request params")
-		http_utils.HttpError(w, "Wrong request params", http.StatusBadRequest)
+		http_utils.HttpError(w, &errors.ValidationError{Details: "Wrong request params"})
 		return
 	}
-	post, err := h.manager.DeleteComment(ctx, postId, commentId)
+	sess := session.FromCtx(ctx)
+	if sess == nil {
+		log.Rlog(r).Warn("Cant load session from request")
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get user info from request"})
+		return
+	}
+	post, err := h.manager.DeleteComment(ctx, postId, commentId, sess.User)
 	if err != nil {
-		if err == usecase.ItemNotFound {
-			http_utils.HttpError(w, "Post not found", http.StatusNotFound)
-			return
-		}
-		log.Clog(ctx).Error("Cant delete comment", log.Fields{"error": err.Error()})
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 
@@ -1,13 +1,17 @@
 package delivery
 
 import (
+	"fmt"
 	"github.com/gorilla/mux"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
 	"golang-stepik-2022q1/reditclone/pkg/posts"
 	"golang-stepik-2022q1/reditclone/pkg/posts/usecase"
 	"golang-stepik-2022q1/reditclone/pkg/session"
 	"golang-stepik-2022q1/reditclone/pkg/utils/http_utils"
+	"golang-stepik-2022q1/reditclone/pkg/views"
 	"net/http"
+	"strconv"
 )
 
 type Handler struct {
@@ -19,23 +23,29 @@ func NewHandler(manager *usecase.Manager) *Handler {
 }
 
 func (h *Handler) List(w http.ResponseWriter, r *http.Request) {
-	items, err := h.manager.GetAll(r.Context())
+	listing, paged, err := listingFromRequest(r)
 	if err != nil {
-		http_utils.HttpError(w, err.Error(), http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
-	http_utils.JsonResp(w, items, http.StatusOK)
+	items, err := h.manager.GetAll(r.Context(), listing)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+	listResp(w, items, paged)
 }
 
 func (h *Handler) Get(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
-	item, err := h.manager.Get(r.Context(), vars["id"])
-	if err != nil {
-		http_utils.HttpError(w, err.Error(), http.StatusInternalServerError)
-		return
+	userId := 0
+	if sess := session.FromCtx(r.Context()); sess != nil {
+		userId = sess.User.Id
 	}
-	if item == nil {
-		http_utils.HttpError(w, "Item not found", http.StatusNotFound)
+	viewer := views.ViewerKey(userId, r.RemoteAddr, r.UserAgent())
+	item, err := h.manager.Get(r.Context(), vars["id"], viewer)
+	if err != nil {
+		http_utils.HttpError(w, err)
 		return
 	}
 	http_utils.JsonResp(w, item, http.StatusOK)
@@ -47,49 +57,116 @@ func (h *Handler) GetByUser(w http.ResponseWriter, r *http.Request) {
 	username, ok := vars["username"]
 	if !ok {
 		log.Clog(ctx).Info("Improper request params")
-		http_utils.HttpError(w, "Wrong id provided", http.StatusBadRequest)
+		http_utils.HttpError(w, errors.NewValidation("Wrong username provided", "username", "is required"))
+		return
+	}
+
+	listing, paged, err := listingFromRequest(r)
+	if err != nil {
+		http_utils.HttpError(w, err)
 		return
 	}
+	items, err := h.manager.FilterByUser(r.Context(), username, listing)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+	listResp(w, items, paged)
+}
 
-	items, err := h.manager.FilterByUser(r.Context(), username)
+func (h *Handler) ListByCategory(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	listing, paged, err := listingFromRequest(r)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+	items, err := h.manager.FilterByCategory(r.Context(), vars["category"], listing)
 	if err != nil {
-		http_utils.HttpError(w, err.Error(), http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
-	http_utils.JsonResp(w, items, http.StatusOK)
+	listResp(w, items, paged)
 }
 
 func (h *Handler) Create(w http.ResponseWriter, r *http.Request) {
 
 	postIn, err := http_utils.FromBody[posts.PostIn](r)
 	if err != nil {
-		http_utils.HttpError(w, "Unmarshalling error", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 	err = http_utils.Validate(postIn)
 	if err != nil {
-		http_utils.HttpError(w, err.Error(), http.StatusUnprocessableEntity)
+		http_utils.HttpError(w, err)
 		return
 	}
 
 	sess := session.FromCtx(r.Context())
 	if sess == nil {
 		log.Rlog(r).Warn("Cant load session from request")
-		http_utils.HttpError(w, "Cant get creator info from request", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get creator info from request"})
 		return
 	}
 	postIn.Author.ID = sess.User.Id
 	postIn.Author.Username = sess.User.Username
-	log.Rlog(r).Info("postIn data collected", log.Fields{"postId": postIn, "sess": sess})
+	log.Rlog(r).Info("postIn data collected", log.Fields{"title": postIn.Title, "category": postIn.Category, "userId": sess.User.Id})
 	post, err := h.manager.Create(r.Context(), postIn)
 	if err != nil {
-		log.Rlog(r).Warn("Cant create users")
-		http_utils.HttpError(w, "Cant create users", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 	http_utils.JsonResp(w, post, http.StatusCreated)
 }
 
+func (h *Handler) Edit(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+	postId := vars["postId"]
+
+	in, err := http_utils.FromBody[posts.PostEditIn](r)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+	err = http_utils.Validate(in)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+
+	sess := session.FromCtx(ctx)
+	if sess == nil {
+		log.Rlog(r).Warn("Cant load session from request")
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get editor info from request"})
+		return
+	}
+	post, err := h.manager.Edit(ctx, postId, in, sess.User)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+	http_utils.JsonResp(w, post, http.StatusOK)
+}
+
+func (h *Handler) Revisions(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	vars := mux.Vars(r)
+
+	sess := session.FromCtx(ctx)
+	if sess == nil {
+		log.Rlog(r).Warn("Cant load session from request")
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get user info from request"})
+		return
+	}
+	revisions, err := h.manager.Revisions(ctx, vars["postId"], sess.User)
+	if err != nil {
+		http_utils.HttpError(w, err)
+		return
+	}
+	http_utils.JsonResp(w, revisions, http.StatusOK)
+}
+
 func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
@@ -97,18 +174,61 @@ func (h *Handler) Delete(w http.ResponseWriter, r *http.Request) {
 
 	if postId == "" {
 		log.Clog(ctx).Info("Improper request params")
-		http_utils.HttpError(w, "Wrong request params", http.StatusBadRequest)
+		http_utils.HttpError(w, errors.NewValidation("Wrong request params", "postId", "is required"))
+		return
+	}
+	sess := session.FromCtx(ctx)
+	if sess == nil {
+		log.Rlog(r).Warn("Cant load session from request")
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get user info from request"})
 		return
 	}
-	post, err := h.manager.DeletePost(ctx, postId)
+	post, err := h.manager.DeletePost(ctx, postId, sess.User)
 	if err != nil {
-		if err == usecase.ItemNotFound {
-			http_utils.HttpError(w, "Post not found", http.StatusNotFound)
-			return
-		}
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 
 	http_utils.JsonResp(w, post, http.StatusOK)
 }
+
+// listingFromRequest reads "sort", "t", "limit" and "cursor" query params.
+// Listing is paginated only when limit or cursor is provided,
+// otherwise the first MaxPageLimit posts are returned as plain array (the bundled frontend expects it).
+func listingFromRequest(r *http.Request) (posts.Listing, bool, error) {
+	query := r.URL.Query()
+	listing := posts.Listing{
+		Sort:   query.Get("sort"),
+		Period: query.Get("t"),
+	}
+	rawLimit, rawCursor := query.Get("limit"), query.Get("cursor")
+	if rawLimit == "" && rawCursor == "" {
+		listing.Page.Limit = posts.MaxPageLimit
+		return listing, false, nil
+	}
+
+	listing.Page.Limit = posts.DefaultPageLimit
+	if rawLimit != "" {
+		limit, err := strconv.Atoi(rawLimit)
+		if err != nil || limit < 1 || limit > posts.MaxPageLimit {
+			return listing, true, errors.NewValidation("Invalid limit", "limit", fmt.Sprintf("should be a number between 1 and %d", posts.MaxPageLimit))
+		}
+		listing.Page.Limit = limit
+	}
+	if rawCursor != "" {
+		cursor, err := posts.DecodeCursor(rawCursor)
+		if err != nil {
+			return listing, true, err
+		}
+		listing.Page.After = cursor
+	}
+	return listing, true, nil
+}
+
+func listResp(w http.ResponseWriter, page *posts.PostsPage, paged bool) {
+	if !paged {
+		http_utils.JsonResp(w, page.Posts, http.StatusOK)
+		return
+	}
+	http_utils.JsonResp(w, page, http.StatusOK)
+}
@@ -2,99 +2,90 @@ package delivery
 
 import (
 	"github.com/gorilla/mux"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
-	"golang-stepik-2022q1/reditclone/pkg/posts/usecase"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
 	"golang-stepik-2022q1/reditclone/pkg/session"
 	"golang-stepik-2022q1/reditclone/pkg/utils/http_utils"
 	"net/http"
 )
 
 func (h *Handler) Upvote(w http.ResponseWriter, r *http.Request) {
-	ctx := r.Context()
-	vars := mux.Vars(r)
-	postId, ok := vars["postId"]
-	if !ok {
-		log.Clog(ctx).Info("Improper request params")
-		http_utils.HttpError(w, "Wrong id provided", http.StatusBadRequest)
-		return
-	}
+	h.vote(w, r, func(vars map[string]string, userId int) (*posts.Post, error) {
+		return h.manager.Upvote(r.Context(), vars["postId"], userId)
+	})
+}
 
-	sess := session.FromCtx(r.Context())
-	if sess == nil {
-		log.Rlog(r).Warn("Cant load session from request")
-		http_utils.HttpError(w, "Cant get creator info from request", http.StatusInternalServerError)
-		return
-	}
+func (h *Handler) Downvote(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, func(vars map[string]string, userId int) (*posts.Post, error) {
+		return h.manager.Downvote(r.Context(), vars["postId"], userId)
+	})
+}
 
-	post, err := h.manager.Upvote(postId, sess.User.Id)
-	if err != nil {
-		if err == usecase.ItemNotFound {
-			http_utils.HttpError(w, "Post not found", http.StatusNotFound)
-			return
-		}
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
-		return
-	}
+func (h *Handler) Unvote(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, func(vars map[string]string, userId int) (*posts.Post, error) {
+		return h.manager.Unvote(r.Context(), vars["postId"], userId)
+	})
+}
 
-	http_utils.JsonResp(w, post, http.StatusCreated)
+func (h *Handler) UpvoteComment(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, func(vars map[string]string, userId int) (*posts.Post, error) {
+		return h.manager.UpvoteComment(r.Context(), vars["postId"], vars["commentId"], userId)
+	})
 }
 
-func (h *Handler) Downvote(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) DownvoteComment(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, func(vars map[string]string, userId int) (*posts.Post, error) {
+		return h.manager.DownvoteComment(r.Context(), vars["postId"], vars["commentId"], userId)
+	})
+}
+
+func (h *Handler) UnvoteComment(w http.ResponseWriter, r *http.Request) {
+	h.vote(w, r, func(vars map[string]string, userId int) (*posts.Post, error) {
+		return h.manager.UnvoteComment(r.Context(), vars["postId"], vars["commentId"], userId)
+	})
+}
+
+// vote does request handling common to post and comment votes
+func (h *Handler) vote(w http.ResponseWriter, r *http.Request, fn func(vars map[string]string, userId int) (*posts.Post, error)) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	postId, ok := vars["postId"]
-	if !ok {
+	if vars["postId"] == "" {
 		log.Clog(ctx).Info("Improper request params")
-		http_utils.HttpError(w, "Wrong id provided", http.StatusBadRequest)
+		http_utils.HttpError(w, errors.NewValidation("Wrong id provided", "postId", "is required"))
 		return
 	}
 
-	sess := session.FromCtx(r.Context())
+	sess := session.FromCtx(ctx)
 	if sess == nil {
 		log.Rlog(r).Warn("Cant load session from request")
-		http_utils.HttpError(w, "Cant get creator info from request", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get creator info from request"})
 		return
 	}
 
-	post, err := h.manager.Downvote(postId, sess.User.Id)
+	post, err := fn(vars, sess.User.Id)
 	if err != nil {
-		if err == usecase.ItemNotFound {
-			http_utils.HttpError(w, "Post not found", http.StatusNotFound)
-			return
-		}
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 
 	http_utils.JsonResp(w, post, http.StatusCreated)
 }
 
-func (h *Handler) Unvote(w http.ResponseWriter, r *http.Request) {
+func (h *Handler) Voters(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	vars := mux.Vars(r)
-	postId, ok := vars["postId"]
-	if !ok {
-		log.Clog(ctx).Info("Improper request params")
-		http_utils.HttpError(w, "Wrong id provided", http.StatusBadRequest)
-		return
-	}
 
-	sess := session.FromCtx(r.Context())
+	sess := session.FromCtx(ctx)
 	if sess == nil {
 		log.Rlog(r).Warn("Cant load session from request")
-		http_utils.HttpError(w, "Cant get creator info from request", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant get user info from request"})
 		return
 	}
-
-	post, err := h.manager.Unvote(postId, sess.User.Id)
+	votes, err := h.manager.Voters(ctx, vars["postId"], sess.User)
 	if err != nil {
-		if err == usecase.ItemNotFound {
-			http_utils.HttpError(w, "Post not found", http.StatusNotFound)
-			return
-		}
-		http_utils.HttpError(w, "Internal error", http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
-
-	http_utils.JsonResp(w, post, http.StatusCreated)
+	http_utils.JsonResp(w, votes, http.StatusOK)
 }
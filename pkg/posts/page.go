@@ -0,0 +1,135 @@
+package posts
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
+	"time"
+)
+
+const (
+	DefaultPageLimit = 25
+	MaxPageLimit     = 100
+)
+
+var InvalidCursorError = errors.NewValidation("Invalid cursor", "cursor", "is malformed or belongs to other sort")
+
+// Cursor points at the last post of a page.
+// For new sort posts are listed newest first, so the next page holds posts
+// created before the cursor (ties are broken by id).
+// Ranked sorts list posts by rank, so the next page holds posts ranked lower than the cursor
+// (ties are broken by id too). Windowed sorts rank posts at the time of the first page, it's kept in Now.
+// Either way new posts and votes never shift other posts between pages,
+// only the post reranked after its page was listed may be skipped or listed again.
+type Cursor struct {
+	Sort    string    `json:"s"`
+	Created time.Time `json:"c"`
+	ID      string    `json:"i,omitempty"`
+	Rank    float64   `json:"r,omitempty"`
+	Now     time.Time `json:"n"`
+}
+
+func CursorOf(post *Post) *Cursor {
+	return &Cursor{Sort: SortNew, Created: post.Created, ID: post.ID}
+}
+
+// RankCursorOf returns cursor of the post listed by rank of the sort
+func RankCursorOf(post *Post, sort string, rank float64) *Cursor {
+	return &Cursor{Sort: sort, ID: post.ID, Rank: rank}
+}
+
+// Precedes reports whether post goes after the cursor in listing order.
+func (c *Cursor) Precedes(post *Post) bool {
+	if post.Created.Equal(c.Created) {
+		return post.ID < c.ID
+	}
+	return post.Created.Before(c.Created)
+}
+
+// PrecedesRank reports whether post of the rank goes after the cursor in ranked listing order.
+func (c *Cursor) PrecedesRank(post *Post, rank float64) bool {
+	if rank == c.Rank {
+		return post.ID < c.ID
+	}
+	return rank < c.Rank
+}
+
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func DecodeCursor(raw string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(raw)
+	if err != nil {
+		return nil, InvalidCursorError
+	}
+	c := &Cursor{}
+	if err = json.Unmarshal(data, c); err != nil || c.Sort == "" {
+		return nil, InvalidCursorError
+	}
+	return c, nil
+}
+
+// Page describes the requested slice of a listing.
+// Zero Limit means no limit. Since and Until bound post creation time when set.
+// Rank names stored rank (see Post.Ranks) posts are ordered by, posts without it are skipped.
+// Empty Rank means newest first.
+type Page struct {
+	Limit int
+	After *Cursor
+	Rank  string
+	Since time.Time
+	Until time.Time
+}
+
+// Contains reports whether post fits page bounds (limit aside)
+func (p Page) Contains(post *Post) bool {
+	if p.Rank != "" {
+		rank, ok := post.Ranks[p.Rank]
+		if !ok || p.After != nil && !p.After.PrecedesRank(post, rank) {
+			return false
+		}
+	} else if p.After != nil && !p.After.Precedes(post) {
+		return false
+	}
+	if !p.Since.IsZero() && post.Created.Before(p.Since) {
+		return false
+	}
+	if !p.Until.IsZero() && post.Created.After(p.Until) {
+		return false
+	}
+	return true
+}
+
+type PostsPage struct {
+	Posts      []*Post `json:"posts"`
+	NextCursor string  `json:"nextCursor,omitempty"`
+}
+
+// Less defines listing order: newest first, then by id descending.
+func Less(a, b *Post) bool {
+	if a.Created.Equal(b.Created) {
+		return a.ID > b.ID
+	}
+	return a.Created.After(b.Created)
+}
+
+// Less defines listing order of the page: by rank descending if page is ranked, then by id descending.
+func (p Page) Less(a, b *Post) bool {
+	if p.Rank == "" {
+		return Less(a, b)
+	}
+	if a.Ranks[p.Rank] == b.Ranks[p.Rank] {
+		return a.ID > b.ID
+	}
+	return a.Ranks[p.Rank] > b.Ranks[p.Rank]
+}
+
+// CursorOf returns cursor of the post in listing order of the page
+func (p Page) CursorOf(post *Post) *Cursor {
+	if p.Rank == "" {
+		return CursorOf(post)
+	}
+	return RankCursorOf(post, p.Rank, post.Ranks[p.Rank])
+}
@@ -0,0 +1,78 @@
+package posts
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+	"time"
+)
+
+func TestCursor_EncodeDecode(t *testing.T) {
+	cursor := &Cursor{Sort: SortNew, Created: time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC), ID: "62237d5b6a4c5d1b2c3f4e5a"}
+
+	decoded, err := DecodeCursor(cursor.Encode())
+	assert.Nil(t, err)
+	assert.True(t, cursor.Created.Equal(decoded.Created))
+	assert.Equal(t, cursor.ID, decoded.ID)
+	assert.Equal(t, cursor.Sort, decoded.Sort)
+
+	ranked := RankCursorOf(&Post{ID: "post"}, SortHot, 3362.738867561933)
+	decoded, err = DecodeCursor(ranked.Encode())
+	assert.Nil(t, err)
+	assert.Equal(t, ranked.Rank, decoded.Rank)
+
+	for _, raw := range []string{"", "not a cursor", "e30"} {
+		_, err = DecodeCursor(raw)
+		assert.Equal(t, InvalidCursorError, err, raw)
+	}
+}
+
+func TestCursor_Precedes(t *testing.T) {
+	now := time.Now()
+	cursor := &Cursor{Sort: SortNew, Created: now, ID: "b"}
+
+	for _, tt := range [...]struct {
+		name     string
+		post     *Post
+		expected bool
+	}{
+		{"Older", &Post{ID: "z", Created: now.Add(-time.Second)}, true},
+		{"Newer", &Post{ID: "a", Created: now.Add(time.Second)}, false},
+		{"Same time lower id", &Post{ID: "a", Created: now}, true},
+		{"Same time higher id", &Post{ID: "c", Created: now}, false},
+		{"Cursor post itself", &Post{ID: "b", Created: now}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, cursor.Precedes(tt.post))
+		})
+	}
+}
+
+func TestCursor_PrecedesRank(t *testing.T) {
+	cursor := RankCursorOf(&Post{ID: "b"}, SortHot, 1.5)
+
+	for _, tt := range [...]struct {
+		name     string
+		rank     float64
+		id       string
+		expected bool
+	}{
+		{"Lower rank", 1, "z", true},
+		{"Higher rank", 2, "a", false},
+		{"Same rank lower id", 1.5, "a", true},
+		{"Same rank higher id", 1.5, "c", false},
+		{"Cursor post itself", 1.5, "b", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, cursor.PrecedesRank(&Post{ID: tt.id}, tt.rank))
+		})
+	}
+}
+
+func TestPage_Contains(t *testing.T) {
+	page := Page{Rank: SortTop, After: RankCursorOf(&Post{ID: "b"}, SortTop, 5)}
+
+	assert.True(t, page.Contains(&Post{ID: "a", Ranks: Ranks{SortTop: 5}}))
+	assert.False(t, page.Contains(&Post{ID: "a", Ranks: Ranks{SortTop: 6}}))
+	// post without rank of the sort isn't listed in ranked feed
+	assert.False(t, page.Contains(&Post{ID: "a", Ranks: Ranks{SortHot: 1}}))
+}
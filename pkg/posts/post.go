@@ -1,15 +1,19 @@
 package posts
 
 import (
-	"errors"
+	"encoding/json"
 	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"sync"
 	"time"
 )
 
 var (
-	MissingTextError = errors.New("Text field missing for text type of post")
-	MissingUrlError  = errors.New("Url field missing for link type of post")
+	MissingTextError     = errors.NewValidation("Text field missing for text type of post", "text", "is required for text post")
+	MissingUrlError      = errors.NewValidation("Url field missing for link type of post", "url", "is required for link post")
+	UrlChangedError      = errors.NewValidation("Url of link post can't be changed", "url", "can't be changed")
+	TextOfLinkError      = errors.NewValidation("Link post can't have text", "text", "is not allowed for link post")
+	InvalidCategoryError = errors.NewValidation("Unknown category", "category", "is unknown")
 )
 
 type Author struct {
@@ -18,35 +22,50 @@ type Author struct {
 }
 
 type Comment struct {
-	Created time.Time `json:"created"`
-	Author  Author    `json:"author"`
-	Body    string    `json:"body"`
-	ID      string    `json:"id"`
+	Created  time.Time  `json:"created"`
+	Author   Author     `json:"author"`
+	Body     string     `json:"body"`
+	ID       string     `json:"id"`
+	ParentID string     `json:"parentId,omitempty" bson:"parentId,omitempty"`
+	Deleted  bool       `json:"deleted,omitempty" bson:"deleted,omitempty"`
+	Replies  []*Comment `json:"replies,omitempty" bson:"-"`
+	Rating   `bson:",inline"`
 }
 
+// Vote is a user vote on a post or comment (target) of a post.
+// Votes are never a part of public post responses, they are shown to moderators only.
 type Vote struct {
-	UserId int `json:"users"`
-	Vote   int `json:"vote"`
+	PostId string    `json:"post" bson:"post"`
+	Target string    `json:"target" bson:"target"`
+	UserId int       `json:"user" bson:"userId"`
+	Vote   int       `json:"vote" bson:"vote"`
+	Voted  time.Time `json:"voted" bson:"voted"`
 }
 
 type Post struct {
 	sync.RWMutex
-	MongoId          primitive.ObjectID `json:"-" bson:"_id"`
-	ID               string             `json:"id"`
-	Views            int                `json:"views"`
-	Type             string             `json:"type"`
-	Title            string             `json:"title"`
-	Category         string             `json:"category"`
-	Text             string             `json:"text"`
-	Url              string             `json:"url"`
-	Upvotes          int                `json:"-"`
-	Downvotes        int                `json:"-"`
-	Score            int                `json:"score"`
-	UpvotePercentage int                `json:"upvotePercentage"`
-	Votes            []*Vote            `json:"votes"`
-	Author           Author             `json:"author"`
-	Comments         []*Comment         `json:"comments"`
-	Created          time.Time          `json:"created"`
+	MongoId   primitive.ObjectID `json:"-" bson:"_id"`
+	ID        string             `json:"id"`
+	Views     int                `json:"views"`
+	Type      string             `json:"type"`
+	Title     string             `json:"title"`
+	Category  string             `json:"category"`
+	Text      string             `json:"text"`
+	Url       string             `json:"url"`
+	Rating    `bson:",inline"`
+	Author    Author      `json:"author"`
+	Comments  []*Comment  `json:"comments"`
+	Created   time.Time   `json:"created"`
+	Edited    *time.Time  `json:"edited,omitempty"`
+	Revisions []*Revision `json:"-"`
+	Ranks     Ranks       `json:"-" bson:"ranks,omitempty"`
+}
+
+// Revision is a previous version of edited post
+type Revision struct {
+	Title   string    `json:"title"`
+	Text    string    `json:"text"`
+	Created time.Time `json:"created"`
 }
 
 type PostIn struct {
@@ -59,6 +78,9 @@ type PostIn struct {
 }
 
 func (in *PostIn) IsValid() error {
+	if !IsCategory(in.Category) {
+		return InvalidCategoryError
+	}
 	if in.Type == "text" && in.Text == "" {
 		return MissingTextError
 	}
@@ -68,7 +90,49 @@ func (in *PostIn) IsValid() error {
 	return nil
 }
 
+// PostEditIn is the editable part of a post
+type PostEditIn struct {
+	Title string `json:"title" valid:"runelength(1|300)"`
+	Text  string `json:"text" valid:"runelength(0|40000),optional"`
+	Url   string `json:"url" valid:"optional"`
+}
+
+// Check validates edit against post type, url of link posts stays fixed
+func (in *PostEditIn) Check(post *Post) error {
+	if post.Type == "link" {
+		if in.Url != "" && in.Url != post.Url {
+			return UrlChangedError
+		}
+		if in.Text != "" {
+			return TextOfLinkError
+		}
+		return nil
+	}
+	if in.Text == "" {
+		return MissingTextError
+	}
+	return nil
+}
+
+// CommentIn is named like Comment, so parentId of a listed comment is sent back as is
 type CommentIn struct {
-	Comment string `json:"comment"`
-	Author  Author
+	Comment  string `json:"comment"`
+	ParentID string `json:"parentId"`
+	Author   Author
+}
+
+// UnmarshalJSON accepts parent_id as well, it was sent by older clients
+func (in *CommentIn) UnmarshalJSON(data []byte) error {
+	type commentIn CommentIn
+	aux := &struct {
+		*commentIn
+		LegacyParentID string `json:"parent_id"`
+	}{commentIn: (*commentIn)(in)}
+	if err := json.Unmarshal(data, aux); err != nil {
+		return err
+	}
+	if in.ParentID == "" {
+		in.ParentID = aux.LegacyParentID
+	}
+	return nil
 }
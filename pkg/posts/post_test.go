@@ -0,0 +1,43 @@
+package posts
+
+import (
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+// public post responses carry only rating counters and requesting user vote
+func TestPost_JSONHidesVoters(t *testing.T) {
+	post := &Post{
+		ID:       "post",
+		Rating:   Rating{Upvotes: 2, Downvotes: 1, Score: 1, UpvotePercentage: 66, MyVote: 1},
+		Comments: []*Comment{{ID: "comment", Rating: Rating{Upvotes: 1, Score: 1, MyVote: -1}}},
+	}
+
+	data, err := json.Marshal(post)
+	assert.NoError(t, err)
+
+	out := map[string]interface{}{}
+	assert.NoError(t, json.Unmarshal(data, &out))
+	assert.NotContains(t, out, "votes")
+	assert.Equal(t, float64(1), out["score"])
+	assert.Equal(t, float64(1), out["myVote"])
+
+	comment := out["comments"].([]interface{})[0].(map[string]interface{})
+	assert.NotContains(t, comment, "votes")
+	assert.Equal(t, float64(-1), comment["myVote"])
+}
+
+// reply is posted with parentId of the listed comment, older clients send parent_id
+func TestCommentIn_ParentID(t *testing.T) {
+	for _, body := range []string{
+		`{"comment": "reply", "parentId": "parent"}`,
+		`{"comment": "reply", "parent_id": "parent"}`,
+		`{"comment": "reply", "parentId": "parent", "parent_id": "other"}`,
+	} {
+		in := &CommentIn{}
+		assert.NoError(t, json.Unmarshal([]byte(body), in), body)
+		assert.Equal(t, "reply", in.Comment, body)
+		assert.Equal(t, "parent", in.ParentID, body)
+	}
+}
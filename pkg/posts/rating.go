@@ -0,0 +1,39 @@
+package posts
+
+// Rating keeps vote statistics of posts and comments.
+// Votes themselves are kept in a separate store, rating holds denormalized counters.
+// All vote rules live here, so posts and comments are voted the same way.
+type Rating struct {
+	Upvotes          int `json:"-" bson:"upvotes"`
+	Downvotes        int `json:"-" bson:"downvotes"`
+	Score            int `json:"score" bson:"score"`
+	UpvotePercentage int `json:"upvotePercentage" bson:"upvotePercentage"`
+	// MyVote is the vote of requesting user, it's computed per request and never stored
+	MyVote int `json:"myVote" bson:"-"`
+}
+
+// VoteDelta returns change of upvotes and downvotes counters
+// when user vote changes from prev to value, zero means no vote.
+func VoteDelta(prev, value int) (up, down int) {
+	for _, v := range [...]struct{ vote, sign int }{{prev, -1}, {value, 1}} {
+		switch v.vote {
+		case 1:
+			up += v.sign
+		case -1:
+			down += v.sign
+		}
+	}
+	return up, down
+}
+
+// Change updates counters when user vote changes from prev to value
+func (r *Rating) Change(prev, value int) {
+	up, down := VoteDelta(prev, value)
+	r.Upvotes += up
+	r.Downvotes += down
+	r.Score = r.Upvotes - r.Downvotes
+	r.UpvotePercentage = 0
+	if total := r.Upvotes + r.Downvotes; total > 0 {
+		r.UpvotePercentage = r.Upvotes * 100 / total
+	}
+}
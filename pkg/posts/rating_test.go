@@ -0,0 +1,43 @@
+package posts
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestVoteDelta(t *testing.T) {
+	for _, tt := range [...]struct {
+		prev, value, up, down int
+	}{
+		{0, 1, 1, 0},
+		{0, -1, 0, 1},
+		{1, 0, -1, 0},
+		{-1, 0, 0, -1},
+		{1, -1, -1, 1},
+		{-1, 1, 1, -1},
+		{1, 1, 0, 0},
+		{0, 0, 0, 0},
+	} {
+		up, down := VoteDelta(tt.prev, tt.value)
+		assert.Equal(t, tt.up, up, "%d -> %d", tt.prev, tt.value)
+		assert.Equal(t, tt.down, down, "%d -> %d", tt.prev, tt.value)
+	}
+}
+
+func TestRating_Change(t *testing.T) {
+	rating := &Rating{}
+
+	rating.Change(0, 1)
+	rating.Change(0, 1)
+	rating.Change(0, -1)
+	assert.Equal(t, Rating{Upvotes: 2, Downvotes: 1, Score: 1, UpvotePercentage: 66}, *rating)
+
+	// changed vote moves from one side to other
+	rating.Change(-1, 1)
+	assert.Equal(t, Rating{Upvotes: 3, Downvotes: 0, Score: 3, UpvotePercentage: 100}, *rating)
+
+	rating.Change(1, 0)
+	rating.Change(1, 0)
+	rating.Change(1, 0)
+	assert.Equal(t, Rating{}, *rating)
+}
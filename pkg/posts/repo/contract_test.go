@@ -0,0 +1,438 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/db/migrations"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// contractRepo is the posts repo contract of usecase.Repo, every backend has to behave the same way
+type contractRepo interface {
+	GetAll(ctx context.Context, page posts.Page) ([]*posts.Post, error)
+	FilterByUserName(ctx context.Context, userName string, page posts.Page) ([]*posts.Post, error)
+	FilterByCategory(ctx context.Context, category string, page posts.Page) ([]*posts.Post, error)
+	Add(ctx context.Context, post *posts.Post) (*posts.Post, error)
+	GetById(ctx context.Context, postId string) (*posts.Post, error)
+	Edit(ctx context.Context, post *posts.Post, revision *posts.Revision, prevEdited *time.Time) (int64, error)
+	Delete(ctx context.Context, postId string) (int64, error)
+	AddComment(ctx context.Context, post *posts.Post, comment *posts.Comment) (int64, error)
+	DeleteComment(ctx context.Context, post *posts.Post, commentId string) (int64, error)
+	TombstoneComment(ctx context.Context, post *posts.Post, commentId string) (int64, error)
+	Vote(ctx context.Context, postId string, userId, value int, ranking posts.Ranking) (*posts.Post, error)
+	Unvote(ctx context.Context, postId string, userId int, ranking posts.Ranking) (*posts.Post, error)
+	Rerank(ctx context.Context, postId string, ranking posts.Ranking) (int64, error)
+	Unranked(ctx context.Context, limit int) ([]string, error)
+	VoteComment(ctx context.Context, postId, commentId string, userId, value int) (*posts.Post, error)
+	UnvoteComment(ctx context.Context, postId, commentId string, userId int) (*posts.Post, error)
+	UserVotes(ctx context.Context, userId int, postIds []string) (map[string]int, error)
+	Votes(ctx context.Context, postId string) ([]*posts.Vote, error)
+	AddViews(ctx context.Context, views map[string]int64) error
+}
+
+// contractSetup returns empty repo and two authors it knows,
+// shared databases may hold other posts, so listings are checked for posts of the authors only
+type contractSetup func(t *testing.T) (contractRepo, [2]posts.Author)
+
+func TestMemRepo_Contract(t *testing.T) {
+	testRepoContract(t, func(t *testing.T) (contractRepo, [2]posts.Author) {
+		return NewMemRepo(), [2]posts.Author{{ID: 1, Username: "John"}, {ID: 2, Username: "Jane"}}
+	})
+}
+
+func TestSqliteRepo_Contract(t *testing.T) {
+	testRepoContract(t, func(t *testing.T) (contractRepo, [2]posts.Author) {
+		conn, err := db.NewSqlite(filepath.Join(t.TempDir(), "reddit.db"))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() })
+		return NewSqliteRepo(conn), contractAuthors(t, conn)
+	})
+}
+
+// TestSqlRepo_Contract runs against real Postgres only when POSTGRES_TEST_DSN is set
+func TestSqlRepo_Contract(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	conn, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, db.Migrate(conn, migrations.Postgres))
+
+	testRepoContract(t, func(t *testing.T) (contractRepo, [2]posts.Author) {
+		return NewSqlRepo(conn), contractAuthors(t, conn)
+	})
+}
+
+// TestMongoRepo_Contract runs against real Mongo only when MONGO_TEST_URI is set,
+// every test gets its own collections
+func TestMongoRepo_Contract(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	defer client.Disconnect(context.Background())
+	mdb := client.Database("reddit_test")
+
+	testRepoContract(t, func(t *testing.T) (contractRepo, [2]posts.Author) {
+		suffix := uuid.New().String()[:8]
+		repo := &MongoRepo{coll: mdb.Collection("posts_contract_" + suffix), votes: mdb.Collection("votes_contract_" + suffix)}
+		for coll, declared := range repo.indexes() {
+			coll := coll
+			require.NoError(t, syncIndexes(context.Background(), coll, declared))
+			t.Cleanup(func() { coll.Drop(context.Background()) })
+		}
+		return repo, [2]posts.Author{{ID: 1, Username: "John"}, {ID: 2, Username: "Jane"}}
+	})
+}
+
+// contractAuthors creates users with unique names, their posts are removed with them after the test
+func contractAuthors(t *testing.T, conn *sql.DB) [2]posts.Author {
+	authors := [2]posts.Author{}
+	for idx := range authors {
+		name := "contract-" + uuid.New().String()[:8]
+		err := conn.QueryRow(`INSERT INTO users (name, pass_hash) VALUES ($1, '') RETURNING id`, name).Scan(&authors[idx].ID)
+		require.NoError(t, err)
+		authors[idx].Username = name
+		id := authors[idx].ID
+		t.Cleanup(func() { conn.Exec(`DELETE FROM users WHERE id = $1`, id) })
+	}
+	return authors
+}
+
+func testRepoContract(t *testing.T, setup contractSetup) {
+	for _, tt := range [...]struct {
+		name string
+		test func(t *testing.T, repo contractRepo, authors [2]posts.Author)
+	}{
+		{"Add and get", contractAddGet},
+		{"Listing", contractListing},
+		{"Ranked listing", contractRankedListing},
+		{"Edit", contractEdit},
+		{"Comments", contractComments},
+		{"Votes", contractVotes},
+		{"Delete", contractDelete},
+		{"Views", contractViews},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, authors := setup(t)
+			tt.test(t, repo, authors)
+		})
+	}
+}
+
+// contractPost adds text post of the author created age ago
+func contractPost(t *testing.T, repo contractRepo, author posts.Author, category string, age time.Duration) *posts.Post {
+	post, err := repo.Add(context.Background(), &posts.Post{
+		ID:       uuid.New().String(),
+		Type:     "text",
+		Title:    "Title",
+		Category: category,
+		Text:     "text",
+		Author:   author,
+		Comments: []*posts.Comment{},
+		Created:  time.Now().UTC().Truncate(time.Millisecond).Add(-age),
+		Ranks:    posts.Ranks{posts.SortTop: 0},
+	})
+	require.NoError(t, err)
+	return post
+}
+
+// postIds returns ids of items in order, only posts listed in known are kept
+func postIds(items []*posts.Post, known ...*posts.Post) []string {
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		for _, post := range known {
+			if item.ID == post.ID {
+				ids = append(ids, item.ID)
+			}
+		}
+	}
+	return ids
+}
+
+func contractAddGet(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	post := contractPost(t, repo, authors[0], "music", 0)
+
+	stored, err := repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, post.Title, stored.Title)
+	assert.Equal(t, post.Text, stored.Text)
+	assert.Equal(t, post.Category, stored.Category)
+	assert.Equal(t, authors[0], stored.Author)
+	assert.True(t, post.Created.Equal(stored.Created))
+	assert.Empty(t, stored.Comments)
+	assert.Nil(t, stored.Edited)
+
+	missing, err := repo.GetById(ctx, uuid.New().String())
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func contractListing(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	oldest := contractPost(t, repo, authors[0], "music", 3*time.Hour)
+	middle := contractPost(t, repo, authors[1], "funny", 2*time.Hour)
+	newest := contractPost(t, repo, authors[0], "music", time.Hour)
+	all := []*posts.Post{oldest, middle, newest}
+
+	items, err := repo.GetAll(ctx, posts.Page{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{newest.ID, middle.ID, oldest.ID}, postIds(items, all...))
+
+	items, err = repo.FilterByUserName(ctx, authors[0].Username, posts.Page{Limit: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{newest.ID}, postIds(items, all...))
+	items, err = repo.FilterByUserName(ctx, authors[0].Username, posts.Page{Limit: 1, After: posts.CursorOf(items[0])})
+	require.NoError(t, err)
+	assert.Equal(t, []string{oldest.ID}, postIds(items, all...))
+
+	items, err = repo.FilterByCategory(ctx, "funny", posts.Page{})
+	require.NoError(t, err)
+	assert.Equal(t, []string{middle.ID}, postIds(items, all...))
+
+	page := posts.Page{Since: middle.Created, Until: middle.Created.Add(30 * time.Minute)}
+	items, err = repo.GetAll(ctx, page)
+	require.NoError(t, err)
+	assert.Equal(t, []string{middle.ID}, postIds(items, all...))
+}
+
+func contractRankedListing(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	top := contractPost(t, repo, authors[0], "music", 2*time.Hour)
+	second := contractPost(t, repo, authors[0], "music", time.Hour)
+	unranked, err := repo.Add(ctx, &posts.Post{
+		ID: uuid.New().String(), Type: "text", Title: "Unranked", Category: "music", Text: "text",
+		Author: authors[0], Comments: []*posts.Comment{}, Created: time.Now().UTC().Truncate(time.Millisecond),
+	})
+	require.NoError(t, err)
+	all := []*posts.Post{top, second, unranked}
+
+	for _, author := range authors {
+		_, err = repo.Vote(ctx, top.ID, author.ID, 1, scoreRanking)
+		require.NoError(t, err)
+	}
+	_, err = repo.Vote(ctx, second.ID, authors[0].ID, 1, scoreRanking)
+	require.NoError(t, err)
+
+	page := posts.Page{Rank: posts.SortTop, Limit: 1}
+	items, err := repo.FilterByUserName(ctx, authors[0].Username, page)
+	require.NoError(t, err)
+	require.Equal(t, []string{top.ID}, postIds(items, all...))
+	assert.Equal(t, 2.0, items[0].Ranks[posts.SortTop])
+
+	page.After = page.CursorOf(items[0])
+	items, err = repo.FilterByUserName(ctx, authors[0].Username, page)
+	require.NoError(t, err)
+	require.Equal(t, []string{second.ID}, postIds(items, all...))
+
+	// post without stored rank is listed after rerank
+	ids, err := repo.Unranked(ctx, posts.MaxPageLimit)
+	require.NoError(t, err)
+	assert.Contains(t, ids, unranked.ID)
+	assert.NotContains(t, ids, top.ID)
+	reranked, err := repo.Rerank(ctx, unranked.ID, scoreRanking)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), reranked)
+	ids, err = repo.Unranked(ctx, posts.MaxPageLimit)
+	require.NoError(t, err)
+	assert.NotContains(t, ids, unranked.ID)
+	page.After = page.CursorOf(items[0])
+	items, err = repo.FilterByUserName(ctx, authors[0].Username, page)
+	require.NoError(t, err)
+	assert.Equal(t, []string{unranked.ID}, postIds(items, all...))
+
+	reranked, err = repo.Rerank(ctx, uuid.New().String(), scoreRanking)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), reranked)
+}
+
+func contractEdit(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	post := contractPost(t, repo, authors[0], "music", 0)
+	revision := &posts.Revision{Title: post.Title, Text: post.Text, Created: post.Created}
+	edited := time.Now().UTC().Truncate(time.Millisecond)
+	post.Title, post.Text, post.Edited = "New title", "new text", &edited
+
+	updated, err := repo.Edit(ctx, post, revision, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), updated)
+
+	// edit of the version read before the first edit is refused
+	stale := &posts.Post{ID: post.ID, MongoId: post.MongoId, Title: "Stale title", Text: "stale text", Edited: &edited}
+	updated, err = repo.Edit(ctx, stale, revision, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+
+	stored, err := repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "New title", stored.Title)
+	assert.Equal(t, "new text", stored.Text)
+	require.NotNil(t, stored.Edited)
+	assert.True(t, edited.Equal(*stored.Edited))
+	require.Len(t, stored.Revisions, 1)
+	assert.Equal(t, "Title", stored.Revisions[0].Title)
+
+	// edit of the stored version is applied
+	second := &posts.Revision{Title: stored.Title, Text: stored.Text, Created: *stored.Edited}
+	edited = edited.Add(time.Second)
+	post.Title, post.Edited = "Newer title", &edited
+	updated, err = repo.Edit(ctx, post, second, stored.Edited)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), updated)
+	stored, err = repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, "Newer title", stored.Title)
+	assert.Len(t, stored.Revisions, 2)
+
+	updated, err = repo.Edit(ctx, &posts.Post{ID: uuid.New().String()}, revision, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), updated)
+}
+
+func contractComments(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	post := contractPost(t, repo, authors[0], "music", 0)
+	created := time.Now().UTC().Truncate(time.Millisecond)
+	parent := &posts.Comment{ID: uuid.New().String(), Author: authors[1], Body: "parent", Created: created}
+	reply := &posts.Comment{ID: uuid.New().String(), Author: authors[0], Body: "reply", ParentID: parent.ID, Created: created.Add(time.Second)}
+	for _, comment := range []*posts.Comment{parent, reply} {
+		added, err := repo.AddComment(ctx, post, comment)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), added)
+	}
+
+	stored, err := repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Comments, 2)
+	assert.Equal(t, parent.ID, stored.Comments[0].ID)
+	assert.Equal(t, authors[1], stored.Comments[0].Author)
+	assert.Equal(t, parent.ID, stored.Comments[1].ParentID)
+
+	tombstoned, err := repo.TombstoneComment(ctx, post, parent.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), tombstoned)
+	deleted, err := repo.DeleteComment(ctx, post, reply.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+
+	stored, err = repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	require.Len(t, stored.Comments, 1)
+	assert.True(t, stored.Comments[0].Deleted)
+	assert.Equal(t, posts.DeletedCommentBody, stored.Comments[0].Body)
+	assert.Equal(t, posts.Author{}, stored.Comments[0].Author)
+
+	deleted, err = repo.DeleteComment(ctx, post, reply.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+}
+
+func contractVotes(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	post := contractPost(t, repo, authors[0], "music", 0)
+	comment := &posts.Comment{ID: uuid.New().String(), Author: authors[1], Body: "comment", Created: time.Now().UTC()}
+	_, err := repo.AddComment(ctx, post, comment)
+	require.NoError(t, err)
+	john, jane := authors[0].ID, authors[1].ID
+
+	voted, err := repo.Vote(ctx, post.ID, john, 1, scoreRanking)
+	require.NoError(t, err)
+	require.NotNil(t, voted)
+	assert.Equal(t, posts.Rating{Upvotes: 1, Score: 1, UpvotePercentage: 100}, voted.Rating)
+	// repeated vote changes nothing, changed vote moves both counters
+	voted, err = repo.Vote(ctx, post.ID, john, 1, scoreRanking)
+	require.NoError(t, err)
+	assert.Equal(t, 1, voted.Score)
+	voted, err = repo.Vote(ctx, post.ID, jane, -1, scoreRanking)
+	require.NoError(t, err)
+	voted, err = repo.Vote(ctx, post.ID, jane, 1, scoreRanking)
+	require.NoError(t, err)
+	assert.Equal(t, posts.Rating{Upvotes: 2, Score: 2, UpvotePercentage: 100}, voted.Rating)
+	voted, err = repo.Unvote(ctx, post.ID, john, scoreRanking)
+	require.NoError(t, err)
+	assert.Equal(t, posts.Rating{Upvotes: 1, Score: 1, UpvotePercentage: 100}, voted.Rating)
+
+	voted, err = repo.VoteComment(ctx, post.ID, comment.ID, john, -1)
+	require.NoError(t, err)
+	require.Len(t, voted.Comments, 1)
+	assert.Equal(t, -1, voted.Comments[0].Score)
+	assert.Equal(t, 1, voted.Score)
+
+	votes, err := repo.UserVotes(ctx, john, []string{post.ID})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]int{comment.ID: -1}, votes)
+	all, err := repo.Votes(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	voted, err = repo.UnvoteComment(ctx, post.ID, comment.ID, john)
+	require.NoError(t, err)
+	assert.Equal(t, 0, voted.Comments[0].Score)
+
+	// missing targets aren't errors
+	voted, err = repo.Vote(ctx, uuid.New().String(), john, 1, scoreRanking)
+	assert.NoError(t, err)
+	assert.Nil(t, voted)
+	voted, err = repo.VoteComment(ctx, post.ID, uuid.New().String(), john, 1)
+	assert.NoError(t, err)
+	assert.Nil(t, voted)
+}
+
+func contractDelete(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	post := contractPost(t, repo, authors[0], "music", 0)
+	_, err := repo.Vote(ctx, post.ID, authors[1].ID, 1, scoreRanking)
+	require.NoError(t, err)
+
+	deleted, err := repo.Delete(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	stored, err := repo.GetById(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+	// votes are removed with the post
+	votes, err := repo.Votes(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Empty(t, votes)
+
+	deleted, err = repo.Delete(ctx, post.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	// ids are opaque to clients, malformed one is just missing
+	deleted, err = repo.Delete(ctx, "malformed")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), deleted)
+	stored, err = repo.GetById(ctx, "malformed")
+	assert.NoError(t, err)
+	assert.Nil(t, stored)
+}
+
+func contractViews(t *testing.T, repo contractRepo, authors [2]posts.Author) {
+	ctx := context.Background()
+	post := contractPost(t, repo, authors[0], "music", 0)
+
+	require.NoError(t, repo.AddViews(ctx, map[string]int64{post.ID: 3}))
+	require.NoError(t, repo.AddViews(ctx, map[string]int64{post.ID: 2, uuid.New().String(): 1}))
+
+	stored, err := repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 5, stored.Views)
+}
@@ -1,53 +1,82 @@
 package repo
 
 import (
-	"errors"
+	"context"
 	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"sort"
 	"sync"
+	"time"
 )
 
+// MemRepo keeps posts in memory, it is used for development without Mongo and in tests.
+// Posts are copied on the way in and out, the same way as they are (de)serialized by MongoRepo.
 type MemRepo struct {
 	sync.RWMutex
 	data []*posts.Post
+	// votes by target id, every target keeps user votes by user id
+	votes map[string]map[int]*posts.Vote
 }
 
 func NewMemRepo() *MemRepo {
 	return &MemRepo{
-		data: make([]*posts.Post, 0, 10),
+		data:  make([]*posts.Post, 0, 10),
+		votes: make(map[string]map[int]*posts.Vote),
 	}
 }
 
-func (repo *MemRepo) GetAll() ([]*posts.Post, error) {
-	repo.RLock()
-	defer repo.RUnlock()
-	return repo.data, nil
+func (repo *MemRepo) GetAll(ctx context.Context, page posts.Page) ([]*posts.Post, error) {
+	return repo.filter(func(post *posts.Post) bool { return true }, page), nil
+}
+
+func (repo *MemRepo) FilterByUserName(ctx context.Context, userName string, page posts.Page) ([]*posts.Post, error) {
+	return repo.filter(func(post *posts.Post) bool {
+		return post.Author.Username == userName
+	}, page), nil
+}
+
+func (repo *MemRepo) FilterByCategory(ctx context.Context, category string, page posts.Page) ([]*posts.Post, error) {
+	return repo.filter(func(post *posts.Post) bool {
+		return post.Category == category
+	}, page), nil
 }
 
-func (repo *MemRepo) FilterByUserName(userName string) ([]*posts.Post, error) {
+// filter returns posts matching fn and page bounds in listing order
+func (repo *MemRepo) filter(fn func(*posts.Post) bool, page posts.Page) []*posts.Post {
 	repo.RLock()
 	defer repo.RUnlock()
 
-	userPosts := make([]*posts.Post, 0, 10)
+	items := make([]*posts.Post, 0, 10)
 	for _, post := range repo.data {
-		if post.Author.Username == userName {
-			userPosts = append(userPosts, post)
+		if page.Contains(post) && fn(post) {
+			items = append(items, post)
 		}
 	}
-	return userPosts, nil
+	sort.Slice(items, func(i, j int) bool { return page.Less(items[i], items[j]) })
+	if page.Limit > 0 && len(items) > page.Limit {
+		items = items[:page.Limit]
+	}
+	for idx, item := range items {
+		items[idx] = clonePost(item)
+	}
+	return items
 }
 
-func (repo *MemRepo) Add(item *posts.Post) (*posts.Post, error) {
+func (repo *MemRepo) Add(ctx context.Context, item *posts.Post) (*posts.Post, error) {
 	repo.Lock()
 	defer repo.Unlock()
 
-	repo.data = append(repo.data, item)
+	repo.data = append(repo.data, clonePost(item))
 	return item, nil
 }
 
-func (repo *MemRepo) GetById(id string) (*posts.Post, error) {
+func (repo *MemRepo) GetById(ctx context.Context, id string) (*posts.Post, error) {
 	repo.RLock()
 	defer repo.RUnlock()
-	return repo.getById(id), nil
+	post := repo.getById(id)
+	if post == nil {
+		return nil, nil
+	}
+	return clonePost(post), nil
 }
 
 func (repo *MemRepo) getById(id string) *posts.Post {
@@ -59,19 +88,31 @@ func (repo *MemRepo) getById(id string) *posts.Post {
 	return nil
 }
 
-func (repo *MemRepo) Update(post *posts.Post) (*posts.Post, error) {
-	// as soon as we store and share posts as struct reference,
-	// there is no need to replace item in memory storage after it was updated.
-	// so here we only check that item exists
-	for _, item := range repo.data {
-		if item == post {
-			return item, nil
-		}
+// Edit replaces post title and text and stores previous version as revision,
+// post edited since prevEdited (the edit time it was read with) is left as is
+func (repo *MemRepo) Edit(ctx context.Context, post *posts.Post, revision *posts.Revision, prevEdited *time.Time) (int64, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	stored := repo.getById(post.ID)
+	if stored == nil {
+		return 0, nil
+	}
+	if (stored.Edited == nil) != (prevEdited == nil) || prevEdited != nil && !stored.Edited.Equal(*prevEdited) {
+		return 0, nil
 	}
-	return nil, errors.New("item not found")
+	stored.Title = post.Title
+	stored.Text = post.Text
+	if post.Edited != nil {
+		edited := *post.Edited
+		stored.Edited = &edited
+	}
+	rev := *revision
+	stored.Revisions = append(stored.Revisions, &rev)
+	return 1, nil
 }
 
-func (repo *MemRepo) Delete(postId string) (*posts.Post, error) {
+func (repo *MemRepo) Delete(ctx context.Context, postId string) (int64, error) {
 	repo.Lock()
 	defer repo.Unlock()
 
@@ -79,75 +120,233 @@ func (repo *MemRepo) Delete(postId string) (*posts.Post, error) {
 		if post.ID == postId {
 			repo.data[idx] = repo.data[len(repo.data)-1]
 			repo.data = repo.data[:len(repo.data)-1]
-			return post, nil
+			delete(repo.votes, postId)
+			for _, comment := range post.Comments {
+				delete(repo.votes, comment.ID)
+			}
+			return 1, nil
 		}
 	}
-	return nil, nil
+	return 0, nil
 }
 
-func (repo *MemRepo) AddComment(post *posts.Post, comment *posts.Comment) (*posts.Post, error) {
-	post.Lock()
-	defer post.Unlock()
-	post.Comments = append(post.Comments, comment)
-	return post, nil
+func (repo *MemRepo) AddComment(ctx context.Context, post *posts.Post, comment *posts.Comment) (int64, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	stored := repo.getById(post.ID)
+	if stored == nil {
+		return 0, nil
+	}
+	stored.Comments = append(stored.Comments, cloneComment(comment))
+	return 1, nil
 }
 
-func (repo *MemRepo) DeleteComment(post *posts.Post, commentId string) (*posts.Post, error) {
-	post.Lock()
-	defer post.Unlock()
-	for idx, comment := range post.Comments {
+func (repo *MemRepo) DeleteComment(ctx context.Context, post *posts.Post, commentId string) (int64, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	stored := repo.getById(post.ID)
+	if stored == nil {
+		return 0, nil
+	}
+	for idx, comment := range stored.Comments {
 		if comment.ID == commentId {
-			post.Comments[idx] = post.Comments[len(post.Comments)-1]
-			post.Comments = post.Comments[:len(post.Comments)-1]
+			stored.Comments = append(stored.Comments[:idx:idx], stored.Comments[idx+1:]...)
+			delete(repo.votes, commentId)
+			return 1, nil
+		}
+	}
+	return 0, nil
+}
+
+func (repo *MemRepo) TombstoneComment(ctx context.Context, post *posts.Post, commentId string) (int64, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	stored := repo.getById(post.ID)
+	if stored == nil {
+		return 0, nil
+	}
+	comment := posts.FindComment(stored.Comments, commentId)
+	if comment == nil {
+		return 0, nil
+	}
+	comment.Body = posts.DeletedCommentBody
+	comment.Author = posts.Author{}
+	comment.Deleted = true
+	return 1, nil
+}
+
+func (repo *MemRepo) Vote(ctx context.Context, postId string, userId, value int, ranking posts.Ranking) (*posts.Post, error) {
+	return repo.vote(postId, "", userId, value, ranking)
+}
+
+func (repo *MemRepo) Unvote(ctx context.Context, postId string, userId int, ranking posts.Ranking) (*posts.Post, error) {
+	return repo.vote(postId, "", userId, 0, ranking)
+}
+
+func (repo *MemRepo) VoteComment(ctx context.Context, postId, commentId string, userId, value int) (*posts.Post, error) {
+	return repo.vote(postId, commentId, userId, value, nil)
+}
+
+func (repo *MemRepo) UnvoteComment(ctx context.Context, postId, commentId string, userId int) (*posts.Post, error) {
+	return repo.vote(postId, commentId, userId, 0, nil)
+}
+
+// Rerank recomputes stored ranks of the post
+func (repo *MemRepo) Rerank(ctx context.Context, postId string, ranking posts.Ranking) (int64, error) {
+	repo.Lock()
+	defer repo.Unlock()
+
+	post := repo.getById(postId)
+	if post == nil {
+		return 0, nil
+	}
+	post.Ranks = ranking(post)
+	return 1, nil
+}
+
+// Unranked returns ids of up to limit posts without stored ranks
+func (repo *MemRepo) Unranked(ctx context.Context, limit int) ([]string, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	ids := make([]string, 0, limit)
+	for _, post := range repo.data {
+		if len(ids) == limit {
 			break
 		}
+		if len(post.Ranks) == 0 {
+			ids = append(ids, post.ID)
+		}
 	}
-	return post, nil
+	return ids, nil
 }
 
-func (repo *MemRepo) Vote(post *posts.Post, userId int, score int) (*posts.Post, error) {
-	post.Lock()
-	defer post.Unlock()
+func (repo *MemRepo) UserVotes(ctx context.Context, userId int, postIds []string) (map[string]int, error) {
+	repo.RLock()
+	defer repo.RUnlock()
 
-	exists := false
-	for _, vote := range post.Votes {
-		if vote.UserId == userId {
-			vote.Vote = score
-			calcStats(post, score*2)
-			exists = true
+	votes := make(map[string]int)
+	for _, postId := range postIds {
+		for _, targetVotes := range repo.votes {
+			if vote, ok := targetVotes[userId]; ok && vote.PostId == postId {
+				votes[vote.Target] = vote.Vote
+			}
 		}
 	}
-	if !exists {
-		post.Votes = append(post.Votes, &posts.Vote{userId, score})
-		calcStats(post, score)
+	return votes, nil
+}
+
+func (repo *MemRepo) Votes(ctx context.Context, postId string) ([]*posts.Vote, error) {
+	repo.RLock()
+	defer repo.RUnlock()
+
+	votes := make([]*posts.Vote, 0, 10)
+	for _, targetVotes := range repo.votes {
+		for _, vote := range targetVotes {
+			if vote.PostId == postId {
+				v := *vote
+				votes = append(votes, &v)
+			}
+		}
 	}
-	return post, nil
+	sort.Slice(votes, func(i, j int) bool { return votes[i].Voted.Before(votes[j].Voted) })
+	return votes, nil
 }
 
-func (repo *MemRepo) DeleteVote(post *posts.Post, userId int) (*posts.Post, error) {
-	post.Lock()
-	defer post.Unlock()
+// vote swaps user vote on the post or its comment and moves rating counters,
+// ranks of the post are recomputed by ranking on post votes
+func (repo *MemRepo) vote(postId, commentId string, userId, value int, ranking posts.Ranking) (*posts.Post, error) {
+	repo.Lock()
+	defer repo.Unlock()
 
-	for idx, vote := range post.Votes {
-		if vote.UserId == userId {
-			post.Votes[idx] = post.Votes[len(post.Votes)-1]
-			post.Votes = post.Votes[:len(post.Votes)-1]
+	post := repo.getById(postId)
+	if post == nil {
+		return nil, nil
+	}
 
-			calcStats(post, -vote.Vote)
-			break
+	target, rating := postId, &post.Rating
+	if commentId != "" {
+		comment := posts.FindComment(post.Comments, commentId)
+		if comment == nil || comment.Deleted {
+			return nil, nil
+		}
+		target, rating = commentId, &comment.Rating
+	}
+
+	targetVotes, ok := repo.votes[target]
+	if !ok {
+		targetVotes = make(map[int]*posts.Vote)
+		repo.votes[target] = targetVotes
+	}
+	prev := 0
+	if vote, ok := targetVotes[userId]; ok {
+		prev = vote.Vote
+	}
+	if value == 0 {
+		delete(targetVotes, userId)
+	} else {
+		targetVotes[userId] = &posts.Vote{PostId: postId, Target: target, UserId: userId, Vote: value, Voted: time.Now()}
+	}
+	rating.Change(prev, value)
+	if ranking != nil {
+		post.Ranks = ranking(post)
+	}
+	return clonePost(post), nil
+}
+
+func (repo *MemRepo) AddViews(ctx context.Context, views map[string]int64) error {
+	repo.Lock()
+	defer repo.Unlock()
+	for postId, count := range views {
+		if post := repo.getById(postId); post != nil {
+			post.Views += int(count)
 		}
 	}
-	return post, nil
+	return nil
 }
 
-func (repo *MemRepo) IncViews(post *posts.Post) (*posts.Post, error) {
-	post.Lock()
-	defer post.Unlock()
-	post.Views++
-	return post, nil
+// clonePost copies stored post, so callers can't change storage by returned posts
+// and storage changes don't race with callers
+func clonePost(post *posts.Post) *posts.Post {
+	clone := &posts.Post{
+		MongoId:  post.MongoId,
+		ID:       post.ID,
+		Views:    post.Views,
+		Type:     post.Type,
+		Title:    post.Title,
+		Category: post.Category,
+		Text:     post.Text,
+		Url:      post.Url,
+		Rating:   post.Rating,
+		Author:   post.Author,
+		Comments: make([]*posts.Comment, 0, len(post.Comments)),
+		Created:  post.Created,
+	}
+	if post.Edited != nil {
+		edited := *post.Edited
+		clone.Edited = &edited
+	}
+	for _, comment := range post.Comments {
+		clone.Comments = append(clone.Comments, cloneComment(comment))
+	}
+	for _, revision := range post.Revisions {
+		rev := *revision
+		clone.Revisions = append(clone.Revisions, &rev)
+	}
+	if post.Ranks != nil {
+		clone.Ranks = make(posts.Ranks, len(post.Ranks))
+		for sort, rank := range post.Ranks {
+			clone.Ranks[sort] = rank
+		}
+	}
+	return clone
 }
 
-func calcStats(post *posts.Post, vote int) {
-	post.Score += vote
-	post.UpvotePercentage = int(float32(post.Score) / float32(len(post.Votes)) * 100)
+func cloneComment(comment *posts.Comment) *posts.Comment {
+	clone := *comment
+	clone.Replies = nil
+	return &clone
 }
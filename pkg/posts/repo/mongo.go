@@ -5,82 +5,179 @@ import (
 	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
 	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"time"
 )
 
 const (
 	PostsDb         = "reddit"
 	PostsCollection = "posts"
+	VotesCollection = "votes"
 )
 
 type MongoRepo struct {
-	coll *mongo.Collection
+	coll  *mongo.Collection
+	votes *mongo.Collection
 }
 
 func NewMongoRepo(mdb *mongo.Client) *MongoRepo {
-	return &MongoRepo{mdb.Database(PostsDb).Collection(PostsCollection)}
+	db := mdb.Database(PostsDb)
+	repo := &MongoRepo{
+		coll:  db.Collection(PostsCollection),
+		votes: db.Collection(VotesCollection),
+	}
+	for coll, declared := range repo.indexes() {
+		if err := syncIndexes(context.Background(), coll, declared); err != nil {
+			log.Error("Cant sync indexes", log.Fields{"collection": coll.Name(), "error": err.Error()})
+		}
+	}
+	return repo
 }
 
-func (repo *MongoRepo) GetAll() ([]*posts.Post, error) {
-	items := make([]*posts.Post, 0, 10)
-	res, err := repo.coll.Find(context.Background(), bson.M{})
-	err = res.All(context.Background(), &items)
-	if err != nil {
-		return nil, err
+func (repo *MongoRepo) indexes() map[*mongo.Collection][]indexDef {
+	return map[*mongo.Collection][]indexDef{repo.coll: postIndexes, repo.votes: voteIndexes}
+}
+
+// RebuildIndexes rebuilds indexes which differ from declared ones
+func (repo *MongoRepo) RebuildIndexes(ctx context.Context) error {
+	for coll, declared := range repo.indexes() {
+		if err := rebuildIndexes(ctx, coll, declared); err != nil {
+			return err
+		}
 	}
-	return items, nil
+	return nil
 }
 
-func (repo *MongoRepo) FilterByUserName(userName string) ([]*posts.Post, error) {
+func (repo *MongoRepo) GetAll(ctx context.Context, page posts.Page) (_ []*posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.GetAll", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.GetAll")
+	defer tracing.End(span, &err)
+	return repo.find(ctx, bson.M{}, page)
+}
+
+func (repo *MongoRepo) FilterByUserName(ctx context.Context, userName string, page posts.Page) (_ []*posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.FilterByUserName", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.FilterByUserName")
+	defer tracing.End(span, &err)
+	return repo.find(ctx, bson.M{"author.username": userName}, page)
+}
+
+func (repo *MongoRepo) FilterByCategory(ctx context.Context, category string, page posts.Page) (_ []*posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.FilterByCategory", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.FilterByCategory")
+	defer tracing.End(span, &err)
+	return repo.find(ctx, bson.M{"category": category}, page)
+}
+
+// find returns posts matching filter and page bounds in listing order
+func (repo *MongoRepo) find(ctx context.Context, filter bson.M, page posts.Page) ([]*posts.Post, error) {
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	conditions := bson.A{filter}
+	sort := bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}
+	if page.Rank != "" {
+		field := "ranks." + page.Rank
+		conditions = append(conditions, bson.M{field: bson.M{"$exists": true}})
+		sort = bson.D{{Key: field, Value: -1}, {Key: "_id", Value: -1}}
+	}
+	if page.After != nil {
+		oid, err := primitive.ObjectIDFromHex(page.After.ID)
+		if err != nil {
+			return nil, posts.InvalidCursorError
+		}
+		field, value := "created", interface{}(page.After.Created)
+		if page.Rank != "" {
+			field, value = "ranks."+page.Rank, page.After.Rank
+		}
+		conditions = append(conditions, bson.M{"$or": bson.A{
+			bson.M{field: bson.M{"$lt": value}},
+			bson.M{field: value, "_id": bson.M{"$lt": oid}},
+		}})
+	}
+	if !page.Since.IsZero() {
+		conditions = append(conditions, bson.M{"created": bson.M{"$gte": page.Since}})
+	}
+	if !page.Until.IsZero() {
+		conditions = append(conditions, bson.M{"created": bson.M{"$lte": page.Until}})
+	}
+	if len(conditions) > 1 {
+		filter = bson.M{"$and": conditions}
+	}
+	opts := options.Find().SetSort(sort)
+	if page.Limit > 0 {
+		opts.SetLimit(int64(page.Limit))
+	}
+
 	items := make([]*posts.Post, 0, 10)
-	res, err := repo.coll.Find(context.Background(), bson.M{"author.username": userName})
-	err = res.All(context.Background(), &items)
+	res, err := repo.coll.Find(ctx, filter, opts)
+	if err != nil {
+		return nil, err
+	}
+	err = res.All(ctx, &items)
 	if err != nil {
 		return nil, err
 	}
 	return items, nil
 }
 
-func (repo *MongoRepo) Add(item *posts.Post) (*posts.Post, error) {
+func (repo *MongoRepo) Add(ctx context.Context, item *posts.Post) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Add", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Add")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
 	item.MongoId = primitive.NewObjectID()
 	item.ID = item.MongoId.Hex()
 
-	_, err := repo.coll.InsertOne(context.Background(), item)
+	_, err = repo.coll.InsertOne(ctx, item)
 	if err != nil {
 		return nil, err
 	}
 	return item, nil
 }
 
-func (repo *MongoRepo) GetById(id string) (*posts.Post, error) {
+func (repo *MongoRepo) GetById(ctx context.Context, id string) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.GetById", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.GetById")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
 	item := &posts.Post{}
 	oid, err := primitive.ObjectIDFromHex(id)
 	if err != nil {
-		return nil, err
+		// malformed id can't match any post
+		return nil, nil
+	}
+	err = repo.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(&item)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
 	}
-	err = repo.coll.FindOne(context.Background(), bson.M{"_id": oid}).Decode(&item)
 	if err != nil {
 		return nil, err
 	}
 	return item, nil
 }
 
-func (repo *MongoRepo) Delete(postId string) (int64, error) {
-	oid, err := primitive.ObjectIDFromHex(postId)
-	if err != nil {
-		return 0, err
-	}
-	res, err := repo.coll.DeleteOne(context.Background(), bson.M{"_id": oid})
-	if err != nil {
-		return 0, err
-	}
-	return res.DeletedCount, nil
-}
-
-func (repo *MongoRepo) AddComment(post *posts.Post, comment *posts.Comment) (int64, error) {
-	res, err := repo.coll.UpdateOne(context.Background(),
-		bson.M{"_id": post.MongoId},
-		bson.M{"$push": bson.M{"comments": comment}},
+// Edit replaces post title and text and stores previous version as revision,
+// post edited since prevEdited (the edit time it was read with) is left as is
+func (repo *MongoRepo) Edit(ctx context.Context, post *posts.Post, revision *posts.Revision, prevEdited *time.Time) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Edit", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Edit")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	// document is updated only if it's not edited since it was read, null matches missing edit time too
+	res, err := repo.coll.UpdateOne(ctx,
+		bson.M{"_id": post.MongoId, "edited": prevEdited},
+		bson.M{
+			"$set":  bson.M{"title": post.Title, "text": post.Text, "edited": post.Edited},
+			"$push": bson.M{"revisions": revision},
+		},
 	)
 	if err != nil {
 		return 0, err
@@ -88,66 +185,75 @@ func (repo *MongoRepo) AddComment(post *posts.Post, comment *posts.Comment) (int
 	return res.ModifiedCount, nil
 }
 
-func (repo *MongoRepo) DeleteComment(post *posts.Post, commentId string) (int64, error) {
-	res, err := repo.coll.UpdateOne(context.Background(),
-		bson.M{"_id": post.MongoId},
-		bson.M{"$pull": bson.M{"comments": bson.M{"id": commentId}}},
-	)
-	if err != nil {
-		return 0, err
-	}
-	return res.ModifiedCount, nil
-}
-
-func (repo *MongoRepo) Vote(postId string, vote *posts.Vote) (int64, error) {
-
-	_, err := repo.DeleteVote(postId, vote.UserId)
-
+func (repo *MongoRepo) Delete(ctx context.Context, postId string) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Delete", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Delete")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
 	oid, err := primitive.ObjectIDFromHex(postId)
 	if err != nil {
-		return 0, err
+		// malformed id can't match any post
+		return 0, nil
 	}
-	_, err = repo.deleteVote(oid, vote.UserId)
+	res, err := repo.coll.DeleteOne(ctx, bson.M{"_id": oid})
 	if err != nil {
 		return 0, err
 	}
-	res, err := repo.coll.UpdateOne(context.Background(),
-		bson.M{"_id": oid},
-		bson.M{"$pull": bson.M{"votes": vote}},
-	)
+	// votes of the post and its comments
+	_, err = repo.votes.DeleteMany(ctx, bson.M{"post": postId})
 	if err != nil {
-		return 0, err
+		log.Error("Cant delete votes of post", log.Fields{"postId": postId, "error": err.Error()})
 	}
-	return res.ModifiedCount, err
+	return res.DeletedCount, nil
 }
 
-func (repo *MongoRepo) UpdateStat(postId string, upvote, score int) (int64, error) {
-	oid, err := primitive.ObjectIDFromHex(postId)
-	if err != nil {
-		return 0, err
-	}
-	res, err := repo.coll.UpdateOne(context.Background(),
-		bson.M{"_id": oid},
-		bson.M{"$set": bson.M{"score": score, "upvotePercentage": upvote}},
+func (repo *MongoRepo) AddComment(ctx context.Context, post *posts.Post, comment *posts.Comment) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.AddComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.AddComment")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	res, err := repo.coll.UpdateOne(ctx,
+		bson.M{"_id": post.MongoId},
+		bson.M{"$push": bson.M{"comments": comment}},
 	)
 	if err != nil {
 		return 0, err
 	}
-	return res.ModifiedCount, err
+	return res.ModifiedCount, nil
 }
 
-func (repo *MongoRepo) DeleteVote(postId string, userId int) (int64, error) {
-	oid, err := primitive.ObjectIDFromHex(postId)
+func (repo *MongoRepo) DeleteComment(ctx context.Context, post *posts.Post, commentId string) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.DeleteComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.DeleteComment")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	res, err := repo.coll.UpdateOne(ctx,
+		bson.M{"_id": post.MongoId},
+		bson.M{"$pull": bson.M{"comments": bson.M{"id": commentId}}},
+	)
 	if err != nil {
 		return 0, err
 	}
-	return repo.deleteVote(oid, userId)
+	return res.ModifiedCount, nil
 }
 
-func (repo *MongoRepo) deleteVote(postId primitive.ObjectID, userId int) (int64, error) {
-	res, err := repo.coll.UpdateOne(context.Background(),
-		bson.M{"_id": postId},
-		bson.M{"$pull": bson.M{"votes": bson.M{"userId": userId}}},
+// TombstoneComment hides comment body and author but keeps it in place for its replies
+func (repo *MongoRepo) TombstoneComment(ctx context.Context, post *posts.Post, commentId string) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.TombstoneComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.TombstoneComment")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	res, err := repo.coll.UpdateOne(ctx,
+		bson.M{"_id": post.MongoId, "comments.id": commentId},
+		bson.M{"$set": bson.M{
+			"comments.$.body":    posts.DeletedCommentBody,
+			"comments.$.author":  posts.Author{},
+			"comments.$.deleted": true,
+		}},
 	)
 	if err != nil {
 		return 0, err
@@ -155,6 +261,27 @@ func (repo *MongoRepo) deleteVote(postId primitive.ObjectID, userId int) (int64,
 	return res.ModifiedCount, nil
 }
 
-func (repo *MongoRepo) IncViews(post *posts.Post) (*posts.Post, error) {
-	return nil, nil
+// AddViews increments views of posts in one batch
+func (repo *MongoRepo) AddViews(ctx context.Context, views map[string]int64) (err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.AddViews", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.AddViews")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	updates := make([]mongo.WriteModel, 0, len(views))
+	for postId, count := range views {
+		oid, err := primitive.ObjectIDFromHex(postId)
+		if err != nil {
+			log.Warn("Views of malformed post id skipped", log.Fields{"postId": postId})
+			continue
+		}
+		updates = append(updates, mongo.NewUpdateOneModel().
+			SetFilter(bson.M{"_id": oid}).
+			SetUpdate(bson.M{"$inc": bson.M{"views": count}}))
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	_, err = repo.coll.BulkWrite(ctx, updates, options.BulkWrite().SetOrdered(false))
+	return err
 }
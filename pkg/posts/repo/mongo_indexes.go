@@ -0,0 +1,180 @@
+package repo
+
+import (
+	"context"
+	"fmt"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"sort"
+	"strings"
+)
+
+// indexDef is a declared index, it's named the same way as Mongo names indexes by default,
+// so indexes created before declaration are recognized
+type indexDef struct {
+	Keys   bson.D
+	Unique bool
+}
+
+// indexSpec is an index as it's listed by Mongo
+type indexSpec struct {
+	Name    string `bson:"name"`
+	Key     bson.D `bson:"key"`
+	Unique  bool   `bson:"unique"`
+	Weights bson.M `bson:"weights"`
+}
+
+var postIndexes = []indexDef{
+	// listing of all posts and ranked feeds
+	{Keys: bson.D{{Key: "created", Value: -1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "category", Value: 1}, {Key: "created", Value: -1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "author.username", Value: 1}, {Key: "created", Value: -1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "ranks.hot", Value: -1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "ranks.top", Value: -1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "ranks.controversial", Value: -1}, {Key: "_id", Value: -1}}},
+	{Keys: bson.D{{Key: "title", Value: "text"}, {Key: "text", Value: "text"}}},
+}
+
+var voteIndexes = []indexDef{
+	// unique vote per user and target makes vote upsert safe under concurrency
+	{Keys: bson.D{{Key: "target", Value: 1}, {Key: "userId", Value: 1}}, Unique: true},
+	{Keys: bson.D{{Key: "post", Value: 1}}},
+}
+
+// Name returns default Mongo name of the index, like "category_1_created_-1"
+func (def indexDef) Name() string {
+	parts := make([]string, 0, 2*len(def.Keys))
+	for _, key := range def.Keys {
+		parts = append(parts, key.Key, fmt.Sprint(key.Value))
+	}
+	return strings.Join(parts, "_")
+}
+
+func (def indexDef) Model() mongo.IndexModel {
+	return mongo.IndexModel{
+		Keys:    def.Keys,
+		Options: options.Index().SetName(def.Name()).SetUnique(def.Unique),
+	}
+}
+
+// Matches reports whether existing index is the declared one
+func (def indexDef) Matches(spec indexSpec) bool {
+	if def.Unique != spec.Unique {
+		return false
+	}
+	textFields := make([]string, 0)
+	for _, key := range def.Keys {
+		if key.Value == "text" {
+			textFields = append(textFields, key.Key)
+		}
+	}
+	if len(textFields) > 0 {
+		// text index is listed with weights of fields instead of keys
+		weighted := make([]string, 0, len(spec.Weights))
+		for field := range spec.Weights {
+			weighted = append(weighted, field)
+		}
+		sort.Strings(textFields)
+		sort.Strings(weighted)
+		return strings.Join(textFields, ",") == strings.Join(weighted, ",")
+	}
+	if len(def.Keys) != len(spec.Key) {
+		return false
+	}
+	for idx, key := range def.Keys {
+		// index directions are listed as int32, int64 or double
+		if key.Key != spec.Key[idx].Key || fmt.Sprint(key.Value) != fmt.Sprint(spec.Key[idx].Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// indexDrift compares declared indexes with existing ones:
+// missing should be created, changed should be rebuilt, extra are unknown to the app
+func indexDrift(declared []indexDef, existing []indexSpec) (missing, changed []indexDef, extra []string) {
+	byName := make(map[string]indexSpec, len(existing))
+	for _, spec := range existing {
+		byName[spec.Name] = spec
+	}
+	known := map[string]bool{"_id_": true}
+	for _, def := range declared {
+		known[def.Name()] = true
+		spec, ok := byName[def.Name()]
+		switch {
+		case !ok:
+			missing = append(missing, def)
+		case !def.Matches(spec):
+			changed = append(changed, def)
+		}
+	}
+	for _, spec := range existing {
+		if !known[spec.Name] {
+			extra = append(extra, spec.Name)
+		}
+	}
+	return missing, changed, extra
+}
+
+// listIndexes returns drift of the collection indexes from declared ones
+func listIndexes(ctx context.Context, coll *mongo.Collection, declared []indexDef) (missing, changed []indexDef, extra []string, err error) {
+	cursor, err := coll.Indexes().List(ctx)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	existing := make([]indexSpec, 0, len(declared))
+	if err = cursor.All(ctx, &existing); err != nil {
+		return nil, nil, nil, err
+	}
+	missing, changed, extra = indexDrift(declared, existing)
+	return missing, changed, extra, nil
+}
+
+// syncIndexes creates missing indexes of the collection. Changed and undeclared indexes are only logged:
+// rebuild of an index locks the collection for a while, so it's done by rebuildIndexes on admin demand.
+func syncIndexes(ctx context.Context, coll *mongo.Collection, declared []indexDef) error {
+	missing, changed, extra, err := listIndexes(ctx, coll, declared)
+	if err != nil {
+		return err
+	}
+	for _, name := range extra {
+		log.Warn("Undeclared index", log.Fields{"collection": coll.Name(), "index": name})
+	}
+	for _, def := range changed {
+		log.Warn("Index differs from declared, run rebuild-indexes to rebuild it", log.Fields{"collection": coll.Name(), "index": def.Name()})
+	}
+	return createIndexes(ctx, coll, missing)
+}
+
+// rebuildIndexes drops changed indexes of the collection and creates them as declared, missing ones are created too
+func rebuildIndexes(ctx context.Context, coll *mongo.Collection, declared []indexDef) error {
+	missing, changed, _, err := listIndexes(ctx, coll, declared)
+	if err != nil {
+		return err
+	}
+	for _, def := range changed {
+		log.Warn("Index differs from declared, rebuilding", log.Fields{"collection": coll.Name(), "index": def.Name()})
+		if _, err = coll.Indexes().DropOne(ctx, def.Name()); err != nil {
+			return err
+		}
+	}
+	return createIndexes(ctx, coll, append(missing, changed...))
+}
+
+func createIndexes(ctx context.Context, coll *mongo.Collection, defs []indexDef) error {
+	if len(defs) == 0 {
+		return nil
+	}
+	models := make([]mongo.IndexModel, 0, len(defs))
+	for _, def := range defs {
+		models = append(models, def.Model())
+	}
+	names, err := coll.Indexes().CreateMany(ctx, models)
+	if err != nil {
+		return err
+	}
+	log.Info("Indexes created", log.Fields{"collection": coll.Name(), "indexes": names})
+	return nil
+}
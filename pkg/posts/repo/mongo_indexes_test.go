@@ -0,0 +1,60 @@
+package repo
+
+import (
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+	"testing"
+)
+
+func TestIndexDef_Name(t *testing.T) {
+	assert.Equal(t, "target_1_userId_1", voteIndexes[0].Name())
+	assert.Equal(t, "title_text_text_text", indexDef{Keys: bson.D{{Key: "title", Value: "text"}, {Key: "text", Value: "text"}}}.Name())
+}
+
+func TestIndexDrift(t *testing.T) {
+	category := indexDef{Keys: bson.D{{Key: "category", Value: 1}, {Key: "created", Value: -1}}}
+	text := indexDef{Keys: bson.D{{Key: "title", Value: "text"}, {Key: "text", Value: "text"}}}
+	vote := indexDef{Keys: bson.D{{Key: "target", Value: 1}, {Key: "userId", Value: 1}}, Unique: true}
+	declared := []indexDef{category, text, vote}
+
+	for _, tt := range [...]struct {
+		name     string
+		existing []indexSpec
+		missing  []indexDef
+		changed  []indexDef
+		extra    []string
+	}{
+		{
+			name:    "Empty collection",
+			missing: declared,
+		},
+		{
+			name: "In sync",
+			existing: []indexSpec{
+				{Name: "_id_", Key: bson.D{{Key: "_id", Value: int32(1)}}},
+				// directions may be listed as any number type
+				{Name: category.Name(), Key: bson.D{{Key: "category", Value: int32(1)}, {Key: "created", Value: float64(-1)}}},
+				{Name: text.Name(), Key: bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}}, Weights: bson.M{"text": int32(1), "title": int32(1)}},
+				{Name: vote.Name(), Key: bson.D{{Key: "target", Value: int32(1)}, {Key: "userId", Value: int32(1)}}, Unique: true},
+			},
+		},
+		{
+			name: "Drift",
+			existing: []indexSpec{
+				{Name: category.Name(), Key: bson.D{{Key: "category", Value: int32(1)}, {Key: "created", Value: int32(-1)}}},
+				{Name: text.Name(), Key: bson.D{{Key: "_fts", Value: "text"}, {Key: "_ftsx", Value: int32(1)}}, Weights: bson.M{"title": int32(1)}},
+				{Name: vote.Name(), Key: bson.D{{Key: "target", Value: int32(1)}, {Key: "userId", Value: int32(1)}}},
+				{Name: "manual_1", Key: bson.D{{Key: "manual", Value: int32(1)}}},
+			},
+			changed: []indexDef{text, vote},
+			extra:   []string{"manual_1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			missing, changed, extra := indexDrift(declared, tt.existing)
+			assert.Equal(t, tt.missing, missing)
+			assert.Equal(t, tt.changed, changed)
+			assert.Equal(t, tt.extra, extra)
+		})
+	}
+}
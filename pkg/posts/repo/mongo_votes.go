@@ -0,0 +1,393 @@
+package repo
+
+import (
+	"context"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"strconv"
+	"time"
+)
+
+// Votes are kept in their own collection, one document per (target, user).
+// Post and comments keep only rating counters, which are moved by the difference
+// between previous and new vote in the same transaction as the vote document swap,
+// so concurrent votes never lose updates. Ranks of the post are recomputed in the same transaction.
+// Transactions need Mongo running as replica set.
+
+// Vote records user vote on the post.
+// Returns updated post or nil if post not found.
+func (repo *MongoRepo) Vote(ctx context.Context, postId string, userId, value int, ranking posts.Ranking) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Vote", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Vote")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, "", userId, value, ranking)
+}
+
+func (repo *MongoRepo) Unvote(ctx context.Context, postId string, userId int, ranking posts.Ranking) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Unvote", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Unvote")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, "", userId, 0, ranking)
+}
+
+// VoteComment records user vote on the comment.
+// Returns updated post or nil if post or alive comment not found.
+func (repo *MongoRepo) VoteComment(ctx context.Context, postId, commentId string, userId, value int) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.VoteComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.VoteComment")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, commentId, userId, value, nil)
+}
+
+func (repo *MongoRepo) UnvoteComment(ctx context.Context, postId, commentId string, userId int) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.UnvoteComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.UnvoteComment")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, commentId, userId, 0, nil)
+}
+
+// Rerank recomputes stored ranks of the post. Post is read and updated in one transaction,
+// so vote recorded meanwhile conflicts with it and reranking is retried.
+func (repo *MongoRepo) Rerank(ctx context.Context, postId string, ranking posts.Ranking) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Rerank", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Rerank")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	oid, err := primitive.ObjectIDFromHex(postId)
+	if err != nil {
+		return 0, nil
+	}
+	sess, err := repo.coll.Database().Client().StartSession()
+	if err != nil {
+		return 0, err
+	}
+	defer sess.EndSession(ctx)
+
+	res, err := sess.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+		item, err := repo.findPost(sc, oid)
+		if err != nil || item == nil {
+			return int64(0), err
+		}
+		res, err := repo.coll.UpdateOne(sc, bson.M{"_id": oid}, bson.M{"$set": bson.M{"ranks": ranking(item)}})
+		if err != nil {
+			return int64(0), err
+		}
+		return res.MatchedCount, nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return res.(int64), nil
+}
+
+// Unranked returns ids of up to limit posts without stored ranks, like posts created before ranks were stored
+func (repo *MongoRepo) Unranked(ctx context.Context, limit int) (_ []string, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Unranked", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Unranked")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	opts := options.Find().SetProjection(bson.M{"_id": 1}).SetLimit(int64(limit))
+	res, err := repo.coll.Find(ctx, bson.M{"ranks": bson.M{"$exists": false}}, opts)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]struct {
+		MongoId primitive.ObjectID `bson:"_id"`
+	}, 0, limit)
+	if err = res.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.MongoId.Hex())
+	}
+	return ids, nil
+}
+
+// UserVotes returns votes of the user on the posts and their comments by target id
+func (repo *MongoRepo) UserVotes(ctx context.Context, userId int, postIds []string) (_ map[string]int, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.UserVotes", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.UserVotes")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	res, err := repo.votes.Find(ctx, bson.M{"post": bson.M{"$in": postIds}, "userId": userId})
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*posts.Vote, 0, len(postIds))
+	if err = res.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	votes := make(map[string]int, len(items))
+	for _, vote := range items {
+		votes[vote.Target] = vote.Vote
+	}
+	return votes, nil
+}
+
+// Votes returns all votes on the post and its comments
+func (repo *MongoRepo) Votes(ctx context.Context, postId string) (_ []*posts.Vote, err error) {
+	defer metrics.ObserveRepo(metrics.Mongo, "posts.Votes", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Mongo, "posts.Votes")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	// migrated votes share time of the post, they are kept in order they were moved
+	opts := options.Find().SetSort(bson.D{{Key: "voted", Value: 1}, {Key: "_id", Value: 1}})
+	res, err := repo.votes.Find(ctx, bson.M{"post": postId}, opts)
+	if err != nil {
+		return nil, err
+	}
+	items := make([]*posts.Vote, 0, 10)
+	if err = res.All(ctx, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// vote swaps user vote on the target and moves rating counters by the difference,
+// ranks of the post are recomputed by ranking on post votes.
+// Vote document and counters are changed in one transaction, so they never drift apart,
+// and post deleted meanwhile conflicts with the transaction instead of getting orphan votes.
+// Zero value removes the vote.
+func (repo *MongoRepo) vote(ctx context.Context, postId, commentId string, userId, value int, ranking posts.Ranking) (*posts.Post, error) {
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	oid, err := primitive.ObjectIDFromHex(postId)
+	if err != nil {
+		return nil, nil
+	}
+	sess, err := repo.coll.Database().Client().StartSession()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.EndSession(ctx)
+
+	vote := &posts.Vote{PostId: postId, Target: postId, UserId: userId, Vote: value}
+	if commentId != "" {
+		vote.Target = commentId
+	}
+	record := func(sc mongo.SessionContext) (interface{}, error) {
+		return repo.recordVote(sc, oid, commentId, vote, ranking)
+	}
+	res, err := sess.WithTransaction(ctx, record)
+	if mongo.IsDuplicateKeyError(err) {
+		// concurrent first vote of the same user won the upsert, now the vote document exists
+		res, err = sess.WithTransaction(ctx, record)
+	}
+	if err == mongo.ErrNoDocuments {
+		// post was deleted meanwhile
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	post, _ := res.(*posts.Post)
+	return post, nil
+}
+
+// recordVote is the transaction body of vote, nil post means post or comment not found
+func (repo *MongoRepo) recordVote(ctx mongo.SessionContext, oid primitive.ObjectID, commentId string, vote *posts.Vote, ranking posts.Ranking) (*posts.Post, error) {
+	filter := bson.M{"_id": oid}
+	if commentId != "" {
+		filter["comments"] = bson.M{"$elemMatch": bson.M{"id": commentId, "deleted": bson.M{"$ne": true}}}
+	}
+	count, err := repo.coll.CountDocuments(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, nil
+	}
+
+	prev, err := repo.swapVote(ctx, vote)
+	if err != nil {
+		return nil, err
+	}
+	if prev == vote.Vote {
+		return repo.findPost(ctx, oid)
+	}
+
+	up, down := posts.VoteDelta(prev, vote.Vote)
+	pipeline := bson.A{bson.M{"$replaceWith": bson.M{"$mergeObjects": bson.A{"$$ROOT", ratingExpr("$$ROOT", up, down)}}}}
+	if commentId != "" {
+		pipeline = bson.A{bson.M{"$set": bson.M{"comments": bson.M{"$map": bson.M{
+			"input": "$comments",
+			"as":    "comment",
+			"in": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{"$$comment.id", commentId}},
+				bson.M{"$mergeObjects": bson.A{"$$comment", ratingExpr("$$comment", up, down)}},
+				"$$comment",
+			}},
+		}}}}}
+	}
+
+	item := &posts.Post{}
+	err = repo.coll.FindOneAndUpdate(ctx, bson.M{"_id": oid}, pipeline,
+		options.FindOneAndUpdate().SetReturnDocument(options.After),
+	).Decode(item)
+	if err != nil {
+		// missing post aborts the transaction as well, so the swapped vote is rolled back
+		return nil, err
+	}
+	if ranking != nil {
+		item.Ranks = ranking(item)
+		_, err = repo.coll.UpdateOne(ctx, bson.M{"_id": oid}, bson.M{"$set": bson.M{"ranks": item.Ranks}})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return item, nil
+}
+
+func (repo *MongoRepo) findPost(ctx context.Context, oid primitive.ObjectID) (*posts.Post, error) {
+	item := &posts.Post{}
+	err := repo.coll.FindOne(ctx, bson.M{"_id": oid}).Decode(item)
+	if err == mongo.ErrNoDocuments {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+// swapVote stores the vote (or removes it for zero vote) and returns the previous one
+func (repo *MongoRepo) swapVote(ctx context.Context, vote *posts.Vote) (int, error) {
+	key := bson.M{"target": vote.Target, "userId": vote.UserId}
+	prev := &posts.Vote{}
+	var err error
+	if vote.Vote == 0 {
+		err = repo.votes.FindOneAndDelete(ctx, key).Decode(prev)
+	} else {
+		opts := options.FindOneAndUpdate().SetUpsert(true).SetReturnDocument(options.Before)
+		update := bson.M{"$set": bson.M{"vote": vote.Vote, "post": vote.PostId, "voted": time.Now()}}
+		err = repo.votes.FindOneAndUpdate(ctx, key, update, opts).Decode(prev)
+	}
+	if err == mongo.ErrNoDocuments {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return prev.Vote, nil
+}
+
+// ratingExpr is expression of posts.Rating counters of doc moved by up and down
+func ratingExpr(doc string, up, down int) bson.M {
+	total := bson.M{"$add": bson.A{"$$up", "$$down"}}
+	return bson.M{"$let": bson.M{
+		"vars": bson.M{
+			"up":   bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{doc + ".upvotes", 0}}, up}},
+			"down": bson.M{"$add": bson.A{bson.M{"$ifNull": bson.A{doc + ".downvotes", 0}}, down}},
+		},
+		"in": bson.M{
+			"upvotes":   "$$up",
+			"downvotes": "$$down",
+			"score":     bson.M{"$subtract": bson.A{"$$up", "$$down"}},
+			"upvotePercentage": bson.M{"$cond": bson.A{
+				bson.M{"$eq": bson.A{total, 0}},
+				0,
+				bson.M{"$toInt": bson.M{"$floor": bson.M{"$divide": bson.A{bson.M{"$multiply": bson.A{"$$up", 100}}, total}}}},
+			}},
+		},
+	}}
+}
+
+type embeddedVote struct {
+	UserId int `bson:"userId"`
+	Vote   int `bson:"vote"`
+}
+
+type postWithEmbeddedVotes struct {
+	MongoId  primitive.ObjectID `bson:"_id"`
+	Created  time.Time          `bson:"created"`
+	Votes    []embeddedVote     `bson:"votes"`
+	Comments []struct {
+		ID    string         `bson:"id"`
+		Votes []embeddedVote `bson:"votes"`
+	} `bson:"comments"`
+}
+
+// MigrateEmbeddedVotes moves votes embedded into post documents to votes collection.
+// Rating counters are recalculated from moved votes, so ranks should be recomputed by rerank after it.
+// It's safe to run it again.
+func (repo *MongoRepo) MigrateEmbeddedVotes(ctx context.Context) (int, error) {
+	cur, err := repo.coll.Find(ctx, bson.M{"$or": bson.A{
+		bson.M{"votes": bson.M{"$exists": true}},
+		bson.M{"comments.votes": bson.M{"$exists": true}},
+	}})
+	if err != nil {
+		return 0, err
+	}
+	defer cur.Close(ctx)
+
+	migrated := 0
+	for cur.Next(ctx) {
+		item := &postWithEmbeddedVotes{}
+		if err = cur.Decode(item); err != nil {
+			return migrated, err
+		}
+		postId := item.MongoId.Hex()
+
+		// embedded votes are removed only when all of them are moved, so failed post is migrated on rerun
+		set := bson.M{}
+		if err = repo.moveVotes(ctx, postId, postId, item.Created, item.Votes, "", set); err != nil {
+			return migrated, err
+		}
+		for idx, comment := range item.Comments {
+			if err = repo.moveVotes(ctx, postId, comment.ID, item.Created, comment.Votes, commentField(idx), set); err != nil {
+				return migrated, err
+			}
+		}
+		unset := bson.M{"votes": ""}
+		for idx := range item.Comments {
+			unset[commentField(idx)+"votes"] = ""
+		}
+
+		_, err = repo.coll.UpdateOne(ctx, bson.M{"_id": item.MongoId}, bson.M{"$set": set, "$unset": unset})
+		if err != nil {
+			return migrated, err
+		}
+		migrated++
+		log.Info("Votes of post migrated", log.Fields{"postId": postId})
+	}
+	return migrated, cur.Err()
+}
+
+// moveVotes upserts embedded votes to votes collection and adds rating counters of target to set.
+// Embedded votes have no time, so moved ones are dated by creation of the post (voted is kept on rerun).
+func (repo *MongoRepo) moveVotes(ctx context.Context, postId, target string, voted time.Time, votes []embeddedVote, prefix string, set bson.M) error {
+	rating := posts.Rating{}
+	for _, vote := range votes {
+		_, err := repo.votes.UpdateOne(ctx,
+			bson.M{"target": target, "userId": vote.UserId},
+			bson.M{"$set": bson.M{"vote": vote.Vote, "post": postId}, "$setOnInsert": bson.M{"voted": voted}},
+			options.Update().SetUpsert(true),
+		)
+		if err != nil {
+			log.Error("Cant migrate vote", log.Fields{"target": target, "userId": vote.UserId, "error": err.Error()})
+			return err
+		}
+		rating.Change(0, vote.Vote)
+	}
+	set[prefix+"upvotes"] = rating.Upvotes
+	set[prefix+"downvotes"] = rating.Downvotes
+	set[prefix+"score"] = rating.Score
+	set[prefix+"upvotePercentage"] = rating.UpvotePercentage
+	return nil
+}
+
+func commentField(idx int) string {
+	return "comments." + strconv.Itoa(idx) + "."
+}
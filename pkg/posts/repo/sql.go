@@ -0,0 +1,689 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"strings"
+	"time"
+)
+
+const (
+	postColumns = `p.id, p.type, p.title, p.category, p.text, p.url, p.author_id, u.name,
+		p.views, p.upvotes, p.downvotes, p.score, p.upvote_percentage, p.created, p.edited`
+	commentColumns = `c.id, c.post_id, c.parent_id, c.author_id, u.name, c.body, c.deleted,
+		c.upvotes, c.downvotes, c.score, c.upvote_percentage, c.created`
+)
+
+// SqlRepo keeps posts, comments, revisions and votes in Postgres (or SQLite) tables next to users.
+// Authors are referenced by user id, so usernames are always taken from users table.
+// Times are stored in UTC, SQLite compares them as text.
+// Ranks of posts are kept in post_ranks table by sort, ranked feeds are read by its index.
+type SqlRepo struct {
+	db *sql.DB
+	// forUpdate locks voted row till the end of transaction,
+	// SQLite has no row locks, its transactions are serialized by single connection
+	forUpdate string
+}
+
+func NewSqlRepo(db *sql.DB) *SqlRepo {
+	return &SqlRepo{db: db, forUpdate: " FOR UPDATE"}
+}
+
+// NewSqliteRepo creates repo for SQLite data file opened by db.NewSqlite
+func NewSqliteRepo(db *sql.DB) *SqlRepo {
+	return &SqlRepo{db: db}
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (repo *SqlRepo) GetAll(ctx context.Context, page posts.Page) (_ []*posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.GetAll", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.GetAll")
+	defer tracing.End(span, &err)
+	return repo.find(ctx, "", nil, page)
+}
+
+func (repo *SqlRepo) FilterByUserName(ctx context.Context, userName string, page posts.Page) (_ []*posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.FilterByUserName", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.FilterByUserName")
+	defer tracing.End(span, &err)
+	return repo.find(ctx, "u.name = ?", []interface{}{userName}, page)
+}
+
+func (repo *SqlRepo) FilterByCategory(ctx context.Context, category string, page posts.Page) (_ []*posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.FilterByCategory", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.FilterByCategory")
+	defer tracing.End(span, &err)
+	return repo.find(ctx, "p.category = ?", []interface{}{category}, page)
+}
+
+// find returns posts matching condition and page bounds in listing order,
+// "?" in condition are replaced by numbered placeholders
+func (repo *SqlRepo) find(ctx context.Context, condition string, args []interface{}, page posts.Page) ([]*posts.Post, error) {
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	query := `SELECT ` + postColumns
+	from := ` FROM posts p JOIN users u ON u.id = p.author_id`
+	order := ` ORDER BY p.created DESC, p.id DESC`
+	if page.Rank != "" {
+		query += `, r.rank`
+		from += ` JOIN post_ranks r ON r.post_id = p.id AND r.sort = ?`
+		order = ` ORDER BY r.rank DESC, p.id DESC`
+		args = append([]interface{}{page.Rank}, args...)
+	}
+
+	conditions := make([]string, 0, 4)
+	if condition != "" {
+		conditions = append(conditions, condition)
+	}
+	switch {
+	case page.After != nil && page.Rank != "":
+		conditions = append(conditions, "(r.rank < ? OR (r.rank = ? AND p.id < ?))")
+		args = append(args, page.After.Rank, page.After.Rank, page.After.ID)
+	case page.After != nil:
+		conditions = append(conditions, "(p.created < ? OR (p.created = ? AND p.id < ?))")
+		args = append(args, page.After.Created.UTC(), page.After.Created.UTC(), page.After.ID)
+	}
+	if !page.Since.IsZero() {
+		conditions = append(conditions, "p.created >= ?")
+		args = append(args, page.Since.UTC())
+	}
+	if !page.Until.IsZero() {
+		conditions = append(conditions, "p.created <= ?")
+		args = append(args, page.Until.UTC())
+	}
+
+	query += from
+	if len(conditions) > 0 {
+		query += ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+	query += order
+	if page.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, page.Limit)
+	}
+
+	rows, err := repo.db.QueryContext(ctx, numberPlaceholders(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	items := make([]*posts.Post, 0, 10)
+	for rows.Next() {
+		var rank float64
+		extra := make([]interface{}, 0, 1)
+		if page.Rank != "" {
+			extra = append(extra, &rank)
+		}
+		post, err := scanPost(rows, extra...)
+		if err != nil {
+			return nil, err
+		}
+		if page.Rank != "" {
+			post.Ranks = posts.Ranks{page.Rank: rank}
+		}
+		items = append(items, post)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if err = repo.loadComments(ctx, items...); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+func (repo *SqlRepo) Add(ctx context.Context, item *posts.Post) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Add", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Add")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO posts (id, type, title, category, text, url, author_id, created)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		item.ID, item.Type, item.Title, item.Category, item.Text, item.Url, item.Author.ID, item.Created.UTC(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	if err = setRanks(ctx, tx, item.ID, item.Ranks); err != nil {
+		return nil, err
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return item, nil
+}
+
+func (repo *SqlRepo) GetById(ctx context.Context, id string) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.GetById", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.GetById")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	row := repo.db.QueryRowContext(ctx, `SELECT `+postColumns+` FROM posts p JOIN users u ON u.id = p.author_id WHERE p.id = $1`, id)
+	post, err := scanPost(row)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = repo.loadComments(ctx, post); err != nil {
+		return nil, err
+	}
+	if err = repo.loadRevisions(ctx, post); err != nil {
+		return nil, err
+	}
+	return post, nil
+}
+
+// Edit replaces post title and text and stores previous version as revision,
+// post edited since prevEdited (the edit time it was read with) is left as is
+func (repo *SqlRepo) Edit(ctx context.Context, post *posts.Post, revision *posts.Revision, prevEdited *time.Time) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Edit", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Edit")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var edited, prev sql.NullTime
+	if post.Edited != nil {
+		edited = sql.NullTime{Time: post.Edited.UTC(), Valid: true}
+	}
+	if prevEdited != nil {
+		prev = sql.NullTime{Time: prevEdited.UTC(), Valid: true}
+	}
+	// row is updated only if it's not edited since it was read
+	res, err := tx.ExecContext(ctx,
+		`UPDATE posts SET title = $1, text = $2, edited = $3 WHERE id = $4 AND (edited = $5 OR edited IS NULL AND $6)`,
+		post.Title, post.Text, edited, post.ID, prev, prevEdited == nil,
+	)
+	if err != nil {
+		return 0, err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil || updated == 0 {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx,
+		`INSERT INTO post_revisions (post_id, title, text, created) VALUES ($1, $2, $3, $4)`,
+		post.ID, revision.Title, revision.Text, revision.Created.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return updated, tx.Commit()
+}
+
+// Delete removes post, its comments, revisions and votes are removed by foreign keys
+func (repo *SqlRepo) Delete(ctx context.Context, postId string) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Delete", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Delete")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	res, err := repo.db.ExecContext(ctx, `DELETE FROM posts WHERE id = $1`, postId)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (repo *SqlRepo) AddComment(ctx context.Context, post *posts.Post, comment *posts.Comment) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.AddComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.AddComment")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	var parentId sql.NullString
+	if comment.ParentID != "" {
+		parentId = sql.NullString{String: comment.ParentID, Valid: true}
+	}
+	res, err := repo.db.ExecContext(ctx,
+		`INSERT INTO comments (id, post_id, parent_id, author_id, body, created) VALUES ($1, $2, $3, $4, $5, $6)`,
+		comment.ID, post.ID, parentId, comment.Author.ID, comment.Body, comment.Created.UTC(),
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (repo *SqlRepo) DeleteComment(ctx context.Context, post *posts.Post, commentId string) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.DeleteComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.DeleteComment")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// comment votes target comment id, they aren't bound by foreign key
+	_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE post_id = $1 AND target = $2`, post.ID, commentId)
+	if err != nil {
+		return 0, err
+	}
+	res, err := tx.ExecContext(ctx, `DELETE FROM comments WHERE id = $1 AND post_id = $2`, commentId, post.ID)
+	if err != nil {
+		return 0, err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	return deleted, tx.Commit()
+}
+
+func (repo *SqlRepo) TombstoneComment(ctx context.Context, post *posts.Post, commentId string) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.TombstoneComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.TombstoneComment")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	res, err := repo.db.ExecContext(ctx,
+		`UPDATE comments SET body = $1, author_id = NULL, deleted = TRUE WHERE id = $2 AND post_id = $3`,
+		posts.DeletedCommentBody, commentId, post.ID,
+	)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (repo *SqlRepo) Vote(ctx context.Context, postId string, userId, value int, ranking posts.Ranking) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Vote", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Vote")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, "", userId, value, ranking)
+}
+
+func (repo *SqlRepo) Unvote(ctx context.Context, postId string, userId int, ranking posts.Ranking) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Unvote", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Unvote")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, "", userId, 0, ranking)
+}
+
+func (repo *SqlRepo) VoteComment(ctx context.Context, postId, commentId string, userId, value int) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.VoteComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.VoteComment")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, commentId, userId, value, nil)
+}
+
+func (repo *SqlRepo) UnvoteComment(ctx context.Context, postId, commentId string, userId int) (_ *posts.Post, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.UnvoteComment", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.UnvoteComment")
+	defer tracing.End(span, &err)
+	return repo.vote(ctx, postId, commentId, userId, 0, nil)
+}
+
+// Rerank recomputes stored ranks of the post, post row is locked meanwhile like on votes
+func (repo *SqlRepo) Rerank(ctx context.Context, postId string, ranking posts.Ranking) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Rerank", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Rerank")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, `SELECT id FROM posts WHERE id = $1`+repo.forUpdate, postId).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if err = rerank(ctx, tx, postId, ranking); err != nil {
+		return 0, err
+	}
+	return 1, tx.Commit()
+}
+
+// Unranked returns ids of up to limit posts without stored ranks, like posts created before ranks were stored
+func (repo *SqlRepo) Unranked(ctx context.Context, limit int) (_ []string, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Unranked", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Unranked")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT id FROM posts p WHERE NOT EXISTS (SELECT 1 FROM post_ranks r WHERE r.post_id = p.id) LIMIT $1`, limit,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	ids := make([]string, 0, limit)
+	for rows.Next() {
+		var id string
+		if err = rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// vote swaps user vote on the post or its comment and moves rating counters in one transaction,
+// ranks of the post are recomputed by ranking on post votes.
+// Target row is locked first, so concurrent votes on the same target are serialized.
+func (repo *SqlRepo) vote(ctx context.Context, postId, commentId string, userId, value int, ranking posts.Ranking) (*posts.Post, error) {
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	table, target := "posts", postId
+	row := tx.QueryRowContext(ctx,
+		`SELECT upvotes, downvotes, score, upvote_percentage FROM posts WHERE id = $1`+repo.forUpdate,
+		postId,
+	)
+	if commentId != "" {
+		table, target = "comments", commentId
+		row = tx.QueryRowContext(ctx,
+			`SELECT upvotes, downvotes, score, upvote_percentage FROM comments
+			WHERE id = $1 AND post_id = $2 AND NOT deleted`+repo.forUpdate,
+			commentId, postId,
+		)
+	}
+	rating := posts.Rating{}
+	err = row.Scan(&rating.Upvotes, &rating.Downvotes, &rating.Score, &rating.UpvotePercentage)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	prev := 0
+	err = tx.QueryRowContext(ctx, `SELECT vote FROM votes WHERE target = $1 AND user_id = $2`, target, userId).Scan(&prev)
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if value == 0 {
+		_, err = tx.ExecContext(ctx, `DELETE FROM votes WHERE target = $1 AND user_id = $2`, target, userId)
+	} else {
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO votes (post_id, target, user_id, vote, voted) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (target, user_id) DO UPDATE SET vote = EXCLUDED.vote, voted = EXCLUDED.voted`,
+			postId, target, userId, value, time.Now().UTC(),
+		)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	rating.Change(prev, value)
+	_, err = tx.ExecContext(ctx,
+		`UPDATE `+table+` SET upvotes = $1, downvotes = $2, score = $3, upvote_percentage = $4 WHERE id = $5`,
+		rating.Upvotes, rating.Downvotes, rating.Score, rating.UpvotePercentage, target,
+	)
+	if err != nil {
+		return nil, err
+	}
+	if ranking != nil {
+		if err = rerank(ctx, tx, postId, ranking); err != nil {
+			return nil, err
+		}
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return repo.GetById(ctx, postId)
+}
+
+func (repo *SqlRepo) UserVotes(ctx context.Context, userId int, postIds []string) (_ map[string]int, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.UserVotes", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.UserVotes")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	votes := make(map[string]int)
+	if len(postIds) == 0 {
+		return votes, nil
+	}
+	args := []interface{}{userId}
+	for _, postId := range postIds {
+		args = append(args, postId)
+	}
+	query := `SELECT target, vote FROM votes WHERE user_id = ? AND post_id IN (` + listPlaceholders(len(postIds)) + `)`
+	rows, err := repo.db.QueryContext(ctx, numberPlaceholders(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var target string
+		var vote int
+		if err = rows.Scan(&target, &vote); err != nil {
+			return nil, err
+		}
+		votes[target] = vote
+	}
+	return votes, rows.Err()
+}
+
+// Votes returns all votes on the post and its comments
+func (repo *SqlRepo) Votes(ctx context.Context, postId string) (_ []*posts.Vote, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.Votes", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.Votes")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT post_id, target, user_id, vote, voted FROM votes WHERE post_id = $1 ORDER BY voted`,
+		postId,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	votes := make([]*posts.Vote, 0, 10)
+	for rows.Next() {
+		vote := &posts.Vote{}
+		if err = rows.Scan(&vote.PostId, &vote.Target, &vote.UserId, &vote.Vote, &vote.Voted); err != nil {
+			return nil, err
+		}
+		votes = append(votes, vote)
+	}
+	return votes, rows.Err()
+}
+
+// AddViews increments views of posts in one transaction
+func (repo *SqlRepo) AddViews(ctx context.Context, views map[string]int64) (err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "posts.AddViews", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "posts.AddViews")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	tx, err := repo.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.PrepareContext(ctx, `UPDATE posts SET views = views + $1 WHERE id = $2`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	for postId, count := range views {
+		if _, err = stmt.ExecContext(ctx, count, postId); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// rerank replaces stored ranks of the post with ranks of its current state
+func rerank(ctx context.Context, tx *sql.Tx, postId string, ranking posts.Ranking) error {
+	row := tx.QueryRowContext(ctx, `SELECT `+postColumns+` FROM posts p JOIN users u ON u.id = p.author_id WHERE p.id = $1`, postId)
+	post, err := scanPost(row)
+	if err != nil {
+		return err
+	}
+	return setRanks(ctx, tx, postId, ranking(post))
+}
+
+// setRanks replaces stored ranks of the post
+func setRanks(ctx context.Context, tx *sql.Tx, postId string, ranks posts.Ranks) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM post_ranks WHERE post_id = $1`, postId); err != nil {
+		return err
+	}
+	for sort, rank := range ranks {
+		_, err := tx.ExecContext(ctx, `INSERT INTO post_ranks (post_id, sort, rank) VALUES ($1, $2, $3)`, postId, sort, rank)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// commentsBatch bounds number of posts whose comments are loaded by one query,
+// Postgres allows at most 65535 parameters in a query
+const commentsBatch = 500
+
+// loadComments fills comments of posts in creation order
+func (repo *SqlRepo) loadComments(ctx context.Context, items ...*posts.Post) error {
+	for len(items) > commentsBatch {
+		if err := repo.loadCommentsBatch(ctx, items[:commentsBatch]...); err != nil {
+			return err
+		}
+		items = items[commentsBatch:]
+	}
+	return repo.loadCommentsBatch(ctx, items...)
+}
+
+func (repo *SqlRepo) loadCommentsBatch(ctx context.Context, items ...*posts.Post) error {
+	if len(items) == 0 {
+		return nil
+	}
+	byId := make(map[string]*posts.Post, len(items))
+	args := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		item.Comments = []*posts.Comment{}
+		byId[item.ID] = item
+		args = append(args, item.ID)
+	}
+	query := `SELECT ` + commentColumns + ` FROM comments c LEFT JOIN users u ON u.id = c.author_id
+		WHERE c.post_id IN (` + listPlaceholders(len(items)) + `) ORDER BY c.created, c.id`
+	rows, err := repo.db.QueryContext(ctx, numberPlaceholders(query), args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		comment := &posts.Comment{}
+		var postId string
+		var parentId, authorName sql.NullString
+		var authorId sql.NullInt64
+		err = rows.Scan(
+			&comment.ID, &postId, &parentId, &authorId, &authorName, &comment.Body, &comment.Deleted,
+			&comment.Upvotes, &comment.Downvotes, &comment.Score, &comment.UpvotePercentage, &comment.Created,
+		)
+		if err != nil {
+			return err
+		}
+		comment.ParentID = parentId.String
+		comment.Author = posts.Author{ID: int(authorId.Int64), Username: authorName.String}
+		if post, ok := byId[postId]; ok {
+			post.Comments = append(post.Comments, comment)
+		}
+	}
+	return rows.Err()
+}
+
+// loadRevisions fills previous versions of post, oldest first
+func (repo *SqlRepo) loadRevisions(ctx context.Context, post *posts.Post) error {
+	rows, err := repo.db.QueryContext(ctx,
+		`SELECT title, text, created FROM post_revisions WHERE post_id = $1 ORDER BY id`,
+		post.ID,
+	)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		revision := &posts.Revision{}
+		if err = rows.Scan(&revision.Title, &revision.Text, &revision.Created); err != nil {
+			return err
+		}
+		post.Revisions = append(post.Revisions, revision)
+	}
+	return rows.Err()
+}
+
+// scanPost reads post columns, extra destinations receive columns selected after them
+func scanPost(row scanner, extra ...interface{}) (*posts.Post, error) {
+	post := &posts.Post{}
+	var edited sql.NullTime
+	dest := []interface{}{
+		&post.ID, &post.Type, &post.Title, &post.Category, &post.Text, &post.Url,
+		&post.Author.ID, &post.Author.Username, &post.Views,
+		&post.Upvotes, &post.Downvotes, &post.Score, &post.UpvotePercentage,
+		&post.Created, &edited,
+	}
+	err := row.Scan(append(dest, extra...)...)
+	if err != nil {
+		return nil, err
+	}
+	if edited.Valid {
+		post.Edited = &edited.Time
+	}
+	return post, nil
+}
+
+// numberPlaceholders replaces "?" placeholders with numbered "$n" ones
+func numberPlaceholders(query string) string {
+	out := strings.Builder{}
+	n := 0
+	for _, ch := range query {
+		if ch == '?' {
+			n++
+			out.WriteString(fmt.Sprintf("$%d", n))
+			continue
+		}
+		out.WriteRune(ch)
+	}
+	return out.String()
+}
+
+func listPlaceholders(n int) string {
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
@@ -0,0 +1,241 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var (
+	postRowColumns = []string{
+		"id", "type", "title", "category", "text", "url", "author_id", "name",
+		"views", "upvotes", "downvotes", "score", "upvote_percentage", "created", "edited",
+	}
+	commentRowColumns = []string{
+		"id", "post_id", "parent_id", "author_id", "name", "body", "deleted",
+		"upvotes", "downvotes", "score", "upvote_percentage", "created",
+	}
+)
+
+type SqlSuite struct {
+	suite.Suite
+	db   *sql.DB
+	mock sqlmock.Sqlmock
+
+	repo *SqlRepo
+}
+
+func (s *SqlSuite) SetupTest() {
+	var err error
+	s.db, s.mock, err = sqlmock.New()
+	require.NoError(s.T(), err)
+	s.repo = NewSqlRepo(s.db)
+}
+
+func (s *SqlSuite) TearDownTest() {
+	require.NoError(s.T(), s.mock.ExpectationsWereMet())
+	s.db.Close()
+}
+
+// expectPost sets up queries of GetById for post with one comment and no revisions
+func (s *SqlSuite) expectPost(postId string, created time.Time, score int) {
+	s.mock.ExpectQuery("SELECT (.+) FROM posts p JOIN users u").
+		WithArgs(postId).
+		WillReturnRows(sqlmock.NewRows(postRowColumns).AddRow(
+			postId, "text", "Title", "music", "text", "", 1, "John",
+			10, score, 0, score, 100, created, nil,
+		))
+	s.mock.ExpectQuery("SELECT (.+) FROM comments c LEFT JOIN users u").
+		WithArgs(postId).
+		WillReturnRows(sqlmock.NewRows(commentRowColumns).AddRow(
+			"comment", postId, nil, nil, nil, posts.DeletedCommentBody, true, 0, 0, 0, 0, created,
+		))
+	s.mock.ExpectQuery("SELECT title, text, created FROM post_revisions").
+		WithArgs(postId).
+		WillReturnRows(sqlmock.NewRows([]string{"title", "text", "created"}))
+}
+
+func (s *SqlSuite) TestGetById() {
+	created := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	s.expectPost("post", created, 2)
+
+	post, err := s.repo.GetById(context.Background(), "post")
+	s.NoError(err)
+	s.Require().NotNil(post)
+	s.Equal(posts.Author{ID: 1, Username: "John"}, post.Author)
+	s.Equal(2, post.Score)
+	s.Equal(10, post.Views)
+	s.Nil(post.Edited)
+	s.Require().Len(post.Comments, 1)
+	// tombstone has no author
+	s.Equal(posts.Author{}, post.Comments[0].Author)
+	s.True(post.Comments[0].Deleted)
+}
+
+func (s *SqlSuite) TestGetByIdNotFound() {
+	dbErr := errors.New("Some db error")
+	for _, tt := range [...]struct {
+		name          string
+		err           error
+		expectedError error
+	}{
+		{"No rows is not an error", sql.ErrNoRows, nil},
+		{"Unexpected error", dbErr, dbErr},
+	} {
+		s.Run(tt.name, func() {
+			s.mock.ExpectQuery("SELECT (.+) FROM posts p").WithArgs("post").WillReturnError(tt.err)
+			post, err := s.repo.GetById(context.Background(), "post")
+			s.Equal(tt.expectedError, err)
+			s.Nil(post)
+		})
+	}
+}
+
+func (s *SqlSuite) TestFindPage() {
+	created := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+	page := posts.Page{Limit: 2, After: &posts.Cursor{Sort: posts.SortNew, Created: created, ID: "cursor"}}
+
+	s.mock.ExpectQuery(regexp.QuoteMeta(
+		"WHERE p.category = $1 AND (p.created < $2 OR (p.created = $3 AND p.id < $4)) ORDER BY p.created DESC, p.id DESC LIMIT $5",
+	)).
+		WithArgs("music", created, created, "cursor", 2).
+		WillReturnRows(sqlmock.NewRows(postRowColumns))
+
+	items, err := s.repo.FilterByCategory(context.Background(), "music", page)
+	s.NoError(err)
+	s.Empty(items)
+}
+
+func (s *SqlSuite) TestFindRankedPage() {
+	page := posts.Page{Limit: 2, Rank: posts.SortHot, After: &posts.Cursor{Sort: posts.SortHot, Rank: 1.5, ID: "cursor"}}
+
+	s.mock.ExpectQuery(regexp.QuoteMeta(
+		"JOIN post_ranks r ON r.post_id = p.id AND r.sort = $1 WHERE p.category = $2 AND (r.rank < $3 OR (r.rank = $4 AND p.id < $5)) ORDER BY r.rank DESC, p.id DESC LIMIT $6",
+	)).
+		WithArgs(posts.SortHot, "music", 1.5, 1.5, "cursor", 2).
+		WillReturnRows(sqlmock.NewRows(append(postRowColumns, "rank")).AddRow(
+			"post", "text", "Title", "music", "text", "", 1, "John",
+			10, 1, 0, 1, 100, time.Now(), nil, 1.2,
+		))
+	s.mock.ExpectQuery("SELECT (.+) FROM comments c").WithArgs("post").WillReturnRows(sqlmock.NewRows(commentRowColumns))
+
+	items, err := s.repo.FilterByCategory(context.Background(), "music", page)
+	s.NoError(err)
+	s.Require().Len(items, 1)
+	s.Equal(posts.Ranks{posts.SortHot: 1.2}, items[0].Ranks)
+}
+
+func (s *SqlSuite) TestVote() {
+	created := time.Date(2022, 3, 1, 12, 0, 0, 0, time.UTC)
+
+	// user changes downvote to upvote
+	s.mock.ExpectBegin()
+	s.mock.ExpectQuery("SELECT upvotes, downvotes, score, upvote_percentage FROM posts (.+) FOR UPDATE").
+		WithArgs("post").
+		WillReturnRows(sqlmock.NewRows([]string{"upvotes", "downvotes", "score", "upvote_percentage"}).AddRow(1, 1, 0, 50))
+	s.mock.ExpectQuery("SELECT vote FROM votes").
+		WithArgs("post", 2).
+		WillReturnRows(sqlmock.NewRows([]string{"vote"}).AddRow(-1))
+	s.mock.ExpectExec("INSERT INTO votes (.+) ON CONFLICT").
+		WithArgs("post", "post", 2, 1, sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("UPDATE posts SET upvotes").
+		WithArgs(2, 0, 2, 100, "post").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// ranks are recomputed from updated post
+	s.mock.ExpectQuery("SELECT (.+) FROM posts p JOIN users u").
+		WithArgs("post").
+		WillReturnRows(sqlmock.NewRows(postRowColumns).AddRow(
+			"post", "text", "Title", "music", "text", "", 1, "John",
+			10, 2, 0, 2, 100, created, nil,
+		))
+	s.mock.ExpectExec("DELETE FROM post_ranks").WithArgs("post").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("INSERT INTO post_ranks").WithArgs("post", posts.SortTop, 2.0).WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+	s.expectPost("post", created, 2)
+
+	post, err := s.repo.Vote(context.Background(), "post", 2, 1, scoreRanking)
+	s.NoError(err)
+	s.Require().NotNil(post)
+	s.Equal(2, post.Score)
+}
+
+func (s *SqlSuite) TestVoteMissingTarget() {
+	for _, tt := range [...]struct {
+		name  string
+		query string
+		args  []driver.Value
+		vote  func() (*posts.Post, error)
+	}{
+		{
+			name:  "Post",
+			query: "FROM posts (.+) FOR UPDATE",
+			args:  []driver.Value{"post"},
+			vote:  func() (*posts.Post, error) { return s.repo.Unvote(context.Background(), "post", 2, scoreRanking) },
+		},
+		{
+			name:  "Deleted comment",
+			query: "FROM comments (.+) AND NOT deleted FOR UPDATE",
+			args:  []driver.Value{"comment", "post"},
+			vote:  func() (*posts.Post, error) { return s.repo.VoteComment(context.Background(), "post", "comment", 2, -1) },
+		},
+	} {
+		s.Run(tt.name, func() {
+			s.mock.ExpectBegin()
+			s.mock.ExpectQuery(tt.query).WithArgs(tt.args...).WillReturnError(sql.ErrNoRows)
+			s.mock.ExpectRollback()
+
+			post, err := tt.vote()
+			s.NoError(err)
+			s.Nil(post)
+		})
+	}
+}
+
+func (s *SqlSuite) TestEditRollback() {
+	dbErr := errors.New("Some db error")
+	edited := time.Now()
+	post := &posts.Post{ID: "post", Title: "New", Text: "new", Edited: &edited}
+	revision := &posts.Revision{Title: "Old", Text: "old"}
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("UPDATE posts SET title").
+		WithArgs(post.Title, post.Text, sqlmock.AnyArg(), post.ID, sqlmock.AnyArg(), true).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectExec("INSERT INTO post_revisions").WillReturnError(dbErr)
+	s.mock.ExpectRollback()
+
+	edits, err := s.repo.Edit(context.Background(), post, revision, nil)
+	s.Equal(dbErr, err)
+	s.Equal(int64(0), edits)
+}
+
+func (s *SqlSuite) TestDeleteComment() {
+	post := &posts.Post{ID: "post"}
+
+	s.mock.ExpectBegin()
+	s.mock.ExpectExec("DELETE FROM votes").WithArgs("post", "comment").WillReturnResult(sqlmock.NewResult(0, 2))
+	s.mock.ExpectExec("DELETE FROM comments").WithArgs("comment", "post").WillReturnResult(sqlmock.NewResult(0, 1))
+	s.mock.ExpectCommit()
+
+	deleted, err := s.repo.DeleteComment(context.Background(), post, "comment")
+	s.NoError(err)
+	s.Equal(int64(1), deleted)
+}
+
+func TestSqlSuite(t *testing.T) {
+	suite.Run(t, new(SqlSuite))
+}
+
+func TestNumberPlaceholders(t *testing.T) {
+	query := numberPlaceholders("a = ? AND b IN (" + listPlaceholders(3) + ")")
+	require.Equal(t, "a = $1 AND b IN ($2, $3, $4)", query)
+}
@@ -0,0 +1,207 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	_ "github.com/jackc/pgx/v4/stdlib"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/db/migrations"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+type voter interface {
+	GetAll(context.Context, posts.Page) ([]*posts.Post, error)
+	Add(context.Context, *posts.Post) (*posts.Post, error)
+	GetById(context.Context, string) (*posts.Post, error)
+	Vote(ctx context.Context, postId string, userId, value int, ranking posts.Ranking) (*posts.Post, error)
+	Unvote(ctx context.Context, postId string, userId int, ranking posts.Ranking) (*posts.Post, error)
+	UserVotes(ctx context.Context, userId int, postIds []string) (map[string]int, error)
+}
+
+func scoreRanking(post *posts.Post) posts.Ranks {
+	return posts.Ranks{posts.SortTop: float64(post.Score)}
+}
+
+// testConcurrentVotes fires parallel votes, every user votes several times
+// and ends up with one known vote, so final score and stored rank must equal sum of final votes
+func testConcurrentVotes(t *testing.T, repo voter) {
+	ctx := context.Background()
+	post, err := repo.Add(ctx, &posts.Post{
+		ID:      "concurrent-votes",
+		Type:    "text",
+		Title:   "Concurrent votes",
+		Author:  posts.Author{ID: 1, Username: "author"},
+		Created: time.Now(),
+		Ranks:   posts.Ranks{posts.SortTop: 0},
+	})
+	require.NoError(t, err)
+
+	const usersCount = 50
+	expectedScore := 0
+	wg := &sync.WaitGroup{}
+	for userId := 1; userId <= usersCount; userId++ {
+		final := 1
+		if userId%3 == 0 {
+			final = -1
+		}
+		expectedScore += final
+
+		wg.Add(1)
+		go func(userId, final int) {
+			defer wg.Done()
+			ops := []func() (*posts.Post, error){
+				func() (*posts.Post, error) { return repo.Vote(ctx, post.ID, userId, -final, scoreRanking) },
+				func() (*posts.Post, error) { return repo.Unvote(ctx, post.ID, userId, scoreRanking) },
+				func() (*posts.Post, error) { return repo.Vote(ctx, post.ID, userId, final, scoreRanking) },
+				// repeated vote is idempotent
+				func() (*posts.Post, error) { return repo.Vote(ctx, post.ID, userId, final, scoreRanking) },
+			}
+			for _, op := range ops {
+				_, err := op()
+				assert.NoError(t, err)
+			}
+		}(userId, final)
+	}
+	wg.Wait()
+
+	stored, err := repo.GetById(ctx, post.ID)
+	require.NoError(t, err)
+	require.NotNil(t, stored)
+	assert.Equal(t, expectedScore, stored.Score)
+	assert.Equal(t, stored.Upvotes-stored.Downvotes, stored.Score)
+	assert.Equal(t, usersCount, stored.Upvotes+stored.Downvotes)
+
+	ranked, err := repo.GetAll(ctx, posts.Page{Rank: posts.SortTop})
+	require.NoError(t, err)
+	for _, item := range ranked {
+		if item.ID == post.ID {
+			assert.Equal(t, float64(expectedScore), item.Ranks[posts.SortTop])
+		}
+	}
+
+	for _, userId := range []int{1, 3} {
+		votes, err := repo.UserVotes(ctx, userId, []string{post.ID})
+		require.NoError(t, err)
+		assert.Len(t, votes, 1)
+	}
+}
+
+func TestMemRepo_ConcurrentVotes(t *testing.T) {
+	testConcurrentVotes(t, NewMemRepo())
+}
+
+// TestMongoRepo_ConcurrentVotes runs against real Mongo only when MONGO_TEST_URI is set
+func TestMongoRepo_ConcurrentVotes(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("reddit_test")
+	repo := &MongoRepo{coll: db.Collection("posts_votes_test"), votes: db.Collection("votes_test")}
+	defer repo.coll.Drop(context.Background())
+	defer repo.votes.Drop(context.Background())
+
+	testConcurrentVotes(t, repo)
+}
+
+// TestSqlRepo_ConcurrentVotes runs against real Postgres only when POSTGRES_TEST_DSN is set
+func TestSqlRepo_ConcurrentVotes(t *testing.T) {
+	dsn := os.Getenv("POSTGRES_TEST_DSN")
+	if dsn == "" {
+		t.Skip("POSTGRES_TEST_DSN not set")
+	}
+	conn, err := sql.Open("pgx", dsn)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, db.Migrate(conn, migrations.Postgres))
+
+	// votes reference users, so voters have to exist
+	for userId := 1; userId <= 50; userId++ {
+		_, err = conn.Exec(
+			`INSERT INTO users (id, name, pass_hash) VALUES ($1, $2, '') ON CONFLICT (id) DO NOTHING`,
+			userId, fmt.Sprintf("voter%d", userId),
+		)
+		require.NoError(t, err)
+	}
+	_, err = conn.Exec(`SELECT setval(pg_get_serial_sequence('users', 'id'), (SELECT MAX(id) FROM users))`)
+	require.NoError(t, err)
+	_, err = conn.Exec(`DELETE FROM posts WHERE id = 'concurrent-votes'`)
+	require.NoError(t, err)
+	defer conn.Exec(`DELETE FROM posts WHERE id = 'concurrent-votes'`)
+
+	testConcurrentVotes(t, NewSqlRepo(conn))
+}
+
+func TestSqliteRepo_ConcurrentVotes(t *testing.T) {
+	conn, err := db.NewSqlite(filepath.Join(t.TempDir(), "reddit.db"))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	// votes reference users, so voters have to exist
+	for userId := 1; userId <= 50; userId++ {
+		_, err := conn.Exec(`INSERT INTO users (name, pass_hash) VALUES ($1, '')`, fmt.Sprintf("voter%d", userId))
+		require.NoError(t, err)
+	}
+
+	testConcurrentVotes(t, NewSqliteRepo(conn))
+}
+
+// TestMongoRepo_MigrateEmbeddedVotes runs against real Mongo only when MONGO_TEST_URI is set
+func TestMongoRepo_MigrateEmbeddedVotes(t *testing.T) {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI not set")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	defer client.Disconnect(context.Background())
+
+	db := client.Database("reddit_test")
+	repo := &MongoRepo{coll: db.Collection("posts_migrate_test"), votes: db.Collection("votes_migrate_test")}
+	defer repo.coll.Drop(context.Background())
+	defer repo.votes.Drop(context.Background())
+
+	created := time.Now().UTC().Truncate(time.Millisecond).Add(-time.Hour)
+	oid := primitive.NewObjectID()
+	_, err = repo.coll.InsertOne(ctx, bson.M{
+		"_id": oid, "id": oid.Hex(), "title": "Title", "created": created,
+		"votes": bson.A{bson.M{"userId": 2, "vote": 1}, bson.M{"userId": 1, "vote": -1}},
+	})
+	require.NoError(t, err)
+
+	migrated, err := repo.MigrateEmbeddedVotes(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, 1, migrated)
+
+	// moved votes are dated by the post and listed in order they were embedded
+	votes, err := repo.Votes(ctx, oid.Hex())
+	require.NoError(t, err)
+	require.Len(t, votes, 2)
+	for idx, userId := range []int{2, 1} {
+		assert.Equal(t, userId, votes[idx].UserId)
+		assert.True(t, created.Equal(votes[idx].Voted))
+	}
+	post, err := repo.GetById(ctx, oid.Hex())
+	require.NoError(t, err)
+	assert.Equal(t, posts.Rating{Upvotes: 1, Downvotes: 1, UpvotePercentage: 50}, post.Rating)
+}
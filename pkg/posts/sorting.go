@@ -0,0 +1,41 @@
+package posts
+
+import "golang-stepik-2022q1/reditclone/pkg/errors"
+
+const (
+	SortHot           = "hot"
+	SortTop           = "top"
+	SortNew           = "new"
+	SortControversial = "controversial"
+	SortRising        = "rising"
+
+	DefaultSort = SortNew
+)
+
+const (
+	PeriodDay   = "day"
+	PeriodWeek  = "week"
+	PeriodMonth = "month"
+	PeriodYear  = "year"
+	PeriodAll   = "all"
+)
+
+var (
+	UnknownSortError   = errors.NewValidation("Unknown sort", "sort", "is unknown")
+	UnknownPeriodError = errors.NewValidation("Unknown time period", "t", "is unknown")
+)
+
+// Listing describes which posts of a feed should be returned and in which order.
+// Period limits the feed to posts created within it, it's applied to top sort only.
+type Listing struct {
+	Sort   string
+	Period string
+	Page   Page
+}
+
+// Ranks keeps ranks of the post by sort, they are stored along with the post,
+// so ranked feeds are ordered by the datastore.
+type Ranks map[string]float64
+
+// Ranking computes stored ranks of the post, it's called on every change of post rating.
+type Ranking func(post *Post) Ranks
@@ -0,0 +1,68 @@
+package posts
+
+import "golang-stepik-2022q1/reditclone/pkg/errors"
+
+// MaxCommentDepth limits nesting of replies, top level comments have depth 0
+const MaxCommentDepth = 10
+
+const DeletedCommentBody = "[deleted]"
+
+var (
+	ParentNotFoundError = errors.NewValidation("Parent comment not found", "parentId", "is not found")
+	CommentTooDeepError = errors.NewValidation("Comment nesting is too deep", "parentId", "is nested too deep")
+)
+
+// BuildThread arranges flat comments into trees by parent id.
+// Comments are copied, so stored ones stay flat.
+// Comments whose parent is missing are treated as top level.
+func BuildThread(comments []*Comment) []*Comment {
+	copies := make(map[string]*Comment, len(comments))
+	for _, comment := range comments {
+		c := *comment
+		c.Replies = nil
+		copies[c.ID] = &c
+	}
+
+	roots := make([]*Comment, 0, len(comments))
+	for _, comment := range comments {
+		c := copies[comment.ID]
+		parent, ok := copies[c.ParentID]
+		if c.ParentID == "" || !ok {
+			roots = append(roots, c)
+			continue
+		}
+		parent.Replies = append(parent.Replies, c)
+	}
+	return roots
+}
+
+// FindComment returns comment with id from flat comments list
+func FindComment(comments []*Comment, id string) *Comment {
+	for _, comment := range comments {
+		if comment.ID == id {
+			return comment
+		}
+	}
+	return nil
+}
+
+// CommentDepth returns nesting level of comment with id
+func CommentDepth(comments []*Comment, id string) int {
+	depth := 0
+	comment := FindComment(comments, id)
+	// depth bound protects from cycles in corrupted data
+	for comment != nil && comment.ParentID != "" && depth <= len(comments) {
+		comment = FindComment(comments, comment.ParentID)
+		depth++
+	}
+	return depth
+}
+
+func HasReplies(comments []*Comment, id string) bool {
+	for _, comment := range comments {
+		if comment.ParentID == id {
+			return true
+		}
+	}
+	return false
+}
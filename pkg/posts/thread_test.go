@@ -0,0 +1,49 @@
+package posts
+
+import (
+	"github.com/stretchr/testify/assert"
+	"testing"
+)
+
+func TestBuildThread(t *testing.T) {
+	comments := []*Comment{
+		{ID: "1", Body: "root"},
+		{ID: "2", Body: "reply", ParentID: "1"},
+		{ID: "3", Body: "reply to reply", ParentID: "2"},
+		{ID: "4", Body: "orphan", ParentID: "missing"},
+		{ID: "5", Body: "second reply", ParentID: "1"},
+	}
+
+	roots := BuildThread(comments)
+
+	assert.Len(t, roots, 2)
+	assert.Equal(t, "1", roots[0].ID)
+	assert.Equal(t, "4", roots[1].ID)
+	assert.Len(t, roots[0].Replies, 2)
+	assert.Equal(t, "2", roots[0].Replies[0].ID)
+	assert.Equal(t, "5", roots[0].Replies[1].ID)
+	assert.Equal(t, "3", roots[0].Replies[0].Replies[0].ID)
+	// stored comments stay flat
+	for _, comment := range comments {
+		assert.Nil(t, comment.Replies)
+	}
+}
+
+func TestCommentDepth(t *testing.T) {
+	comments := []*Comment{
+		{ID: "1"},
+		{ID: "2", ParentID: "1"},
+		{ID: "3", ParentID: "2"},
+		// cycle must not hang
+		{ID: "4", ParentID: "5"},
+		{ID: "5", ParentID: "4"},
+	}
+
+	assert.Equal(t, 0, CommentDepth(comments, "1"))
+	assert.Equal(t, 1, CommentDepth(comments, "2"))
+	assert.Equal(t, 2, CommentDepth(comments, "3"))
+	assert.Equal(t, 0, CommentDepth(comments, "missing"))
+	assert.Greater(t, CommentDepth(comments, "4"), 0)
+	assert.True(t, HasReplies(comments, "2"))
+	assert.False(t, HasReplies(comments, "3"))
+}
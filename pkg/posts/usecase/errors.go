@@ -1,9 +1,11 @@
 package usecase
 
 import (
-	"errors"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 )
 
 var (
-	ItemNotFound = errors.New("Item not found")
+	ItemNotFound = errors.NotFoundError{Resource: "Item"}
+	// EditConflictError means post was edited by concurrent request after it was read for edit
+	EditConflictError = errors.ConflictError{Details: "Post was edited meanwhile, reload it and retry"}
 )
@@ -5,49 +5,196 @@ import (
 	"github.com/google/uuid"
 	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
 	"golang-stepik-2022q1/reditclone/pkg/posts"
-	"golang-stepik-2022q1/reditclone/pkg/posts/repo"
+	"golang-stepik-2022q1/reditclone/pkg/session"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"golang-stepik-2022q1/reditclone/pkg/users"
 	"time"
 )
 
 type Repo interface {
-	GetAll() ([]*posts.Post, error)
-	FilterByUserName(userName string) ([]*posts.Post, error)
-	Add(*posts.Post) (*posts.Post, error)
-	GetById(string) (*posts.Post, error)
-	Delete(postId string) (int64, error)
-	AddComment(post *posts.Post, comment *posts.Comment) (int64, error)
-	DeleteComment(post *posts.Post, commentId string) (int64, error)
-	Vote(post *posts.Post, userId string, score int) (*posts.Post, error)
-	DeleteVote(post *posts.Post, userId string) (*posts.Post, error)
-	IncViews(post *posts.Post) (*posts.Post, error)
+	GetAll(ctx context.Context, page posts.Page) ([]*posts.Post, error)
+	FilterByUserName(ctx context.Context, userName string, page posts.Page) ([]*posts.Post, error)
+	FilterByCategory(ctx context.Context, category string, page posts.Page) ([]*posts.Post, error)
+	Add(ctx context.Context, post *posts.Post) (*posts.Post, error)
+	GetById(ctx context.Context, postId string) (*posts.Post, error)
+	// Edit stores new version of the post only if its edit time is still prevEdited,
+	// zero is returned when post is missing or edited meanwhile
+	Edit(ctx context.Context, post *posts.Post, revision *posts.Revision, prevEdited *time.Time) (int64, error)
+	Delete(ctx context.Context, postId string) (int64, error)
+	AddComment(ctx context.Context, post *posts.Post, comment *posts.Comment) (int64, error)
+	DeleteComment(ctx context.Context, post *posts.Post, commentId string) (int64, error)
+	TombstoneComment(ctx context.Context, post *posts.Post, commentId string) (int64, error)
+	Vote(ctx context.Context, postId string, userId, value int, ranking posts.Ranking) (*posts.Post, error)
+	Unvote(ctx context.Context, postId string, userId int, ranking posts.Ranking) (*posts.Post, error)
+	Rerank(ctx context.Context, postId string, ranking posts.Ranking) (int64, error)
+	// Unranked returns ids of up to limit posts without stored ranks
+	Unranked(ctx context.Context, limit int) ([]string, error)
+	VoteComment(ctx context.Context, postId, commentId string, userId, value int) (*posts.Post, error)
+	UnvoteComment(ctx context.Context, postId, commentId string, userId int) (*posts.Post, error)
+	UserVotes(ctx context.Context, userId int, postIds []string) (map[string]int, error)
+	Votes(ctx context.Context, postId string) ([]*posts.Vote, error)
+	AddViews(ctx context.Context, views map[string]int64) error
+}
+
+// ViewCounter counts post views deduplicated by viewer
+type ViewCounter interface {
+	View(ctx context.Context, postId, viewer string) (bool, error)
 }
 
 type Manager struct {
-	repo *repo.MongoRepo
+	repo          Repo
+	views         ViewCounter
+	rankers       map[string]Ranker
+	windowRankers map[string]WindowRanker
 }
 
-func NewManager(repo *repo.MongoRepo) *Manager {
-	return &Manager{repo: repo}
+func NewManager(repo Repo, views ViewCounter) *Manager {
+	return &Manager{repo: repo, views: views, rankers: DefaultRankers(), windowRankers: DefaultWindowRankers()}
+}
+
+// SetRanker registers ranker for sort, replacing the default one.
+// Stored ranks of existing posts are recomputed by Rerank.
+func (m *Manager) SetRanker(sort string, ranker Ranker) {
+	delete(m.windowRankers, sort)
+	m.rankers[sort] = ranker
+}
+
+// SetWindowRanker registers windowed ranker for sort, replacing the default one
+func (m *Manager) SetWindowRanker(sort string, ranker WindowRanker) {
+	delete(m.rankers, sort)
+	m.windowRankers[sort] = ranker
+}
+
+// ranking computes stored ranks of the post by all rankers
+func (m *Manager) ranking(post *posts.Post) posts.Ranks {
+	ranks := make(posts.Ranks, len(m.rankers))
+	for sort, ranker := range m.rankers {
+		ranks[sort] = ranker.Rank(post)
+	}
+	return ranks
 }
 
-func (m *Manager) GetAll(ctx context.Context) ([]*posts.Post, error) {
-	items, err := m.repo.GetAll()
+func (m *Manager) GetAll(ctx context.Context, listing posts.Listing) (_ *posts.PostsPage, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.GetAll")
+	defer tracing.End(span, &err)
+	return m.list(ctx, listing, m.repo.GetAll)
+}
+
+func (m *Manager) FilterByUser(ctx context.Context, userName string, listing posts.Listing) (_ *posts.PostsPage, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.FilterByUser")
+	defer tracing.End(span, &err)
+	return m.list(ctx, listing, func(ctx context.Context, page posts.Page) ([]*posts.Post, error) {
+		return m.repo.FilterByUserName(ctx, userName, page)
+	})
+}
+
+func (m *Manager) FilterByCategory(ctx context.Context, category string, listing posts.Listing) (_ *posts.PostsPage, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.FilterByCategory")
+	defer tracing.End(span, &err)
+	if !posts.IsCategory(category) {
+		log.Clog(ctx).Info("Unknown category", log.Fields{"category": category})
+		return nil, posts.UnknownCategoryError
+	}
+	return m.list(ctx, listing, func(ctx context.Context, page posts.Page) ([]*posts.Post, error) {
+		return m.repo.FilterByCategory(ctx, category, page)
+	})
+}
+
+func (m *Manager) list(ctx context.Context, listing posts.Listing, fetch func(context.Context, posts.Page) ([]*posts.Post, error)) (*posts.PostsPage, error) {
+	if listing.Sort == "" {
+		listing.Sort = posts.DefaultSort
+	}
+	page := listing.Page
+	if page.After != nil && page.After.Sort != listing.Sort {
+		log.Clog(ctx).Info("Cursor of other sort provided", log.Fields{"sort": listing.Sort})
+		return nil, posts.InvalidCursorError
+	}
+	if ranker, ok := m.windowRankers[listing.Sort]; ok {
+		return m.listWindow(ctx, listing.Sort, page, ranker, fetch)
+	}
+	if listing.Sort != posts.SortNew {
+		if _, ok := m.rankers[listing.Sort]; !ok {
+			log.Clog(ctx).Info("Unknown sort", log.Fields{"sort": listing.Sort})
+			return nil, posts.UnknownSortError
+		}
+		// ranks are stored with posts, so repo orders and pages ranked feed by itself
+		page.Rank = listing.Sort
+	}
+	if listing.Sort == posts.SortTop {
+		since, err := periodStart(listing.Period, time.Now())
+		if err != nil {
+			return nil, err
+		}
+		page.Since = since
+	}
+
+	items, err := fetch(ctx, extendPage(page))
+	if err == posts.InvalidCursorError {
+		return nil, err
+	}
 	if err != nil {
 		log.Clog(ctx).Error("Cant fetch posts", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
-	return items, err
+	out := toPage(items, page)
+	m.setMyVotes(ctx, viewerId(ctx), out.Posts...)
+	return out, nil
 }
 
-func (m *Manager) FilterByUser(ctx context.Context, userName string) ([]*posts.Post, error) {
-	items, err := m.repo.FilterByUserName(userName)
+// listWindow lists feed of windowed ranker. Newest posts of the window are ranked in memory
+// at the time the first page was requested, next pages keep that time in the cursor.
+func (m *Manager) listWindow(ctx context.Context, sort string, page posts.Page, ranker WindowRanker, fetch func(context.Context, posts.Page) ([]*posts.Post, error)) (*posts.PostsPage, error) {
+	// monotonic clock reading is dropped, so ranks are the same when now is decoded from the cursor
+	now := time.Now().Round(0)
+	if page.After != nil {
+		now = page.After.Now
+	}
+	items, err := fetch(ctx, posts.Page{Since: now.Add(-ranker.Window()), Until: now, Limit: maxWindowCandidates})
 	if err != nil {
 		log.Clog(ctx).Error("Cant fetch posts", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
+	}
+	ranks := rank(items, func(post *posts.Post) float64 { return ranker.Rank(post, now) })
+
+	out := &posts.PostsPage{Posts: make([]*posts.Post, 0, len(items))}
+	for _, item := range items {
+		if page.After == nil || page.After.PrecedesRank(item, ranks[item]) {
+			out.Posts = append(out.Posts, item)
+		}
+	}
+	if page.Limit > 0 && len(out.Posts) > page.Limit {
+		out.Posts = out.Posts[:page.Limit]
+		last := out.Posts[page.Limit-1]
+		next := posts.RankCursorOf(last, sort, ranks[last])
+		next.Now = now
+		out.NextCursor = next.Encode()
+	}
+	m.setMyVotes(ctx, viewerId(ctx), out.Posts...)
+	return out, nil
+}
+
+// extendPage asks repo for one extra item to find out if there is a next page
+func extendPage(page posts.Page) posts.Page {
+	if page.Limit > 0 {
+		page.Limit++
 	}
-	return items, err
+	return page
 }
 
-func (m *Manager) Create(ctx context.Context, in *posts.PostIn) (*posts.Post, error) {
+func toPage(items []*posts.Post, page posts.Page) *posts.PostsPage {
+	out := &posts.PostsPage{Posts: items}
+	if page.Limit > 0 && len(items) > page.Limit {
+		out.Posts = items[:page.Limit]
+		out.NextCursor = page.CursorOf(out.Posts[page.Limit-1]).Encode()
+	}
+	return out
+}
+
+func (m *Manager) Create(ctx context.Context, in *posts.PostIn) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Create")
+	defer tracing.End(span, &err)
 	post := &posts.Post{
 		ID:       uuid.New().String(),
 		Type:     in.Type,
@@ -56,86 +203,218 @@ func (m *Manager) Create(ctx context.Context, in *posts.PostIn) (*posts.Post, er
 		Text:     in.Text,
 		Url:      in.Url,
 		Author:   in.Author,
-		Votes:    []*posts.Vote{},
 		Comments: []*posts.Comment{},
 		Created:  time.Now(),
 	}
-	_, err := m.repo.Add(post)
+	post.Ranks = m.ranking(post)
+	_, err = m.repo.Add(ctx, post)
 	if err != nil {
 		log.Clog(ctx).Error("Repo error during post creation", log.Fields{"error": err.Error()})
-		return nil, err
+		return nil, errors.InternalError{Details: err.Error()}
 	}
+	metrics.PostsCreated.Inc()
 	return post, nil
 }
 
-func (m *Manager) Get(ctx context.Context, postId string) (*posts.Post, error) {
-	post, err := m.repo.GetById(postId)
+// Get returns post and counts its view by viewer (see views.ViewerKey)
+func (m *Manager) Get(ctx context.Context, postId, viewer string) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Get")
+	defer tracing.End(span, &err)
+	post, err := m.repo.GetById(ctx, postId)
+	if err != nil {
+		log.Clog(ctx).Error("Repo error during post fetching", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
+	}
 	if post == nil {
 		log.Clog(ctx).Info("Item not found")
 		return nil, ItemNotFound
 	}
-	_, err = m.repo.IncViews(post)
+	_, err = m.views.View(ctx, postId, viewer)
 	if err != nil {
-		log.Clog(ctx).Warn("Cant increment post views")
-		// here is better to return post without fixed view than return error
+		log.Clog(ctx).Warn("Cant count post view", log.Fields{"error": err.Error()})
+		// here is better to return post without counted view than return error
 	}
-	return post, nil
+	return m.present(ctx, viewerId(ctx), post), nil
+}
+
+// Edit changes title and text of the post, only author is allowed to do it
+func (m *Manager) Edit(ctx context.Context, postId string, in *posts.PostEditIn, user session.UserClaims) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Edit")
+	defer tracing.End(span, &err)
+	post, err := m.repo.GetById(ctx, postId)
+	if err != nil {
+		log.Clog(ctx).Error("Repo error during post fetching", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
+	}
+	if post == nil {
+		log.Clog(ctx).Info("Item not found", log.Fields{"id": postId})
+		return nil, ItemNotFound
+	}
+	if post.Author.ID != user.Id {
+		log.Clog(ctx).Info("Edit of other's post", log.Fields{"id": postId, "userId": user.Id})
+		return nil, errors.ForbiddenError{Action: "edit other's post"}
+	}
+	if err = in.Check(post); err != nil {
+		return nil, err
+	}
+
+	revision := &posts.Revision{Title: post.Title, Text: post.Text, Created: post.Created}
+	prevEdited := post.Edited
+	if prevEdited != nil {
+		revision.Created = *prevEdited
+	}
+	edited := time.Now()
+	post.Title = in.Title
+	post.Text = in.Text
+	post.Edited = &edited
+	post.Revisions = append(post.Revisions, revision)
+
+	// concurrent edit would store the same revision and lose the version in between, so it's refused
+	updated, err := m.repo.Edit(ctx, post, revision, prevEdited)
+	if err != nil {
+		log.Clog(ctx).Error("Repo error during post edit", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
+	}
+	if updated == 0 {
+		log.Clog(ctx).Info("Post edited or deleted meanwhile", log.Fields{"id": postId})
+		return nil, EditConflictError
+	}
+	return m.present(ctx, user.Id, post), nil
+}
+
+// Revisions returns previous versions of the post, oldest first
+func (m *Manager) Revisions(ctx context.Context, postId string, user session.UserClaims) (_ []*posts.Revision, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Revisions")
+	defer tracing.End(span, &err)
+	post, err := m.repo.GetById(ctx, postId)
+	if err != nil {
+		log.Clog(ctx).Error("Repo error during post fetching", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
+	}
+	if post == nil {
+		return nil, ItemNotFound
+	}
+	if !canModerate(user, post.Author) {
+		log.Clog(ctx).Info("Revisions of other's post requested", log.Fields{"id": postId, "userId": user.Id})
+		return nil, errors.ForbiddenError{Action: "view revisions of other's post"}
+	}
+	if post.Revisions == nil {
+		return []*posts.Revision{}, nil
+	}
+	return post.Revisions, nil
 }
 
-func (m *Manager) CreateComment(ctx context.Context, postId string, commentIn *posts.CommentIn) (*posts.Post, error) {
-	post, err := m.repo.GetById(postId)
+func (m *Manager) CreateComment(ctx context.Context, postId string, commentIn *posts.CommentIn) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.CreateComment")
+	defer tracing.End(span, &err)
+	post, err := m.repo.GetById(ctx, postId)
 	if err != nil {
 		log.Clog(ctx).Error("Repo error during post fetching", log.Fields{"error": err.Error()})
-		return nil, errors.InternalError{err.Error()}
+		return nil, errors.InternalError{Details: err.Error()}
 	}
 	if post == nil {
 		log.Clog(ctx).Info("Item not found", log.Fields{"id": postId})
 		return post, ItemNotFound
 	}
+	if commentIn.ParentID != "" {
+		parent := posts.FindComment(post.Comments, commentIn.ParentID)
+		if parent == nil || parent.Deleted {
+			log.Clog(ctx).Info("Parent comment not found", log.Fields{"id": postId, "parentId": commentIn.ParentID})
+			return nil, posts.ParentNotFoundError
+		}
+		if posts.CommentDepth(post.Comments, parent.ID)+1 >= posts.MaxCommentDepth {
+			return nil, posts.CommentTooDeepError
+		}
+	}
 
 	comment := &posts.Comment{
-		Created: time.Now(),
-		Author:  commentIn.Author,
-		Body:    commentIn.Comment,
-		ID:      uuid.New().String(),
+		Created:  time.Now(),
+		Author:   commentIn.Author,
+		Body:     commentIn.Comment,
+		ID:       uuid.New().String(),
+		ParentID: commentIn.ParentID,
 	}
-	_, err = m.repo.AddComment(post, comment)
+	_, err = m.repo.AddComment(ctx, post, comment)
 	if err != nil {
-		return post, errors.InternalError{err.Error()}
+		log.Clog(ctx).Error("Repo error during comment creation", log.Fields{"id": postId, "error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
+	metrics.CommentsCreated.Inc()
 	post.Comments = append(post.Comments, comment)
-	return post, nil
+	return m.present(ctx, commentIn.Author.ID, post), nil
 }
 
-func (m *Manager) DeleteComment(ctx context.Context, postId, commentId string) (*posts.Post, error) {
-	post, err := m.repo.GetById(postId)
+// DeleteComment removes comment, only its author or moderator is allowed to do it
+func (m *Manager) DeleteComment(ctx context.Context, postId, commentId string, user session.UserClaims) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.DeleteComment")
+	defer tracing.End(span, &err)
+	post, err := m.repo.GetById(ctx, postId)
 	if err != nil {
 		log.Clog(ctx).Error("Repo error", log.Fields{"error": err.Error()})
-		return post, err
+		return post, errors.InternalError{Details: err.Error()}
 	}
 	if post == nil {
 		log.Clog(ctx).Info("Post not found", log.Fields{"postId": postId})
 		return post, ItemNotFound
 	}
+	comment := posts.FindComment(post.Comments, commentId)
+	if comment == nil {
+		log.Clog(ctx).Info("Comment not found", log.Fields{"postId": postId, "commentId": commentId})
+		return nil, ItemNotFound
+	}
+	if !canModerate(user, comment.Author) {
+		log.Clog(ctx).Info("Delete of other's comment", log.Fields{"commentId": commentId, "userId": user.Id})
+		return nil, errors.ForbiddenError{Action: "delete other's comment"}
+	}
 
-	_, err = m.repo.DeleteComment(post, commentId)
+	// comment with replies is replaced with tombstone to keep the thread in place
+	if posts.HasReplies(post.Comments, commentId) {
+		_, err = m.repo.TombstoneComment(ctx, post, commentId)
+		if err != nil {
+			log.Clog(ctx).Error("Repo error during comment tombstoning", log.Fields{"postId": postId, "commentId": commentId, "error": err.Error()})
+			return nil, errors.InternalError{Details: err.Error()}
+		}
+		comment.Body = posts.DeletedCommentBody
+		comment.Author = posts.Author{}
+		comment.Deleted = true
+		return m.present(ctx, user.Id, post), nil
+	}
+
+	_, err = m.repo.DeleteComment(ctx, post, commentId)
 	if err != nil {
-		return post, errors.InternalError{err.Error()}
+		log.Clog(ctx).Error("Repo error during comment deletion", log.Fields{"postId": postId, "commentId": commentId, "error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
-	return post, nil
+	remaining := make([]*posts.Comment, 0, len(post.Comments))
+	for _, c := range post.Comments {
+		if c.ID != commentId {
+			remaining = append(remaining, c)
+		}
+	}
+	post.Comments = remaining
+	return m.present(ctx, user.Id, post), nil
 }
 
-func (m *Manager) DeletePost(ctx context.Context, postId string) (*posts.Post, error) {
-	post, err := m.repo.GetById(postId)
+// DeletePost removes post, only its author or moderator is allowed to do it
+func (m *Manager) DeletePost(ctx context.Context, postId string, user session.UserClaims) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.DeletePost")
+	defer tracing.End(span, &err)
+	post, err := m.repo.GetById(ctx, postId)
 	if err != nil {
-		return nil, err
+		log.Clog(ctx).Error("Repo error during post fetching", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
 	if post == nil {
 		return nil, ItemNotFound
 	}
-	deletedCount, err := m.repo.Delete(postId)
+	if !canModerate(user, post.Author) {
+		log.Clog(ctx).Info("Delete of other's post", log.Fields{"postId": postId, "userId": user.Id})
+		return nil, errors.ForbiddenError{Action: "delete other's post"}
+	}
+	deletedCount, err := m.repo.Delete(ctx, postId)
 	if err != nil {
-		return nil, err
+		log.Clog(ctx).Error("Repo error during post deletion", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
 	if deletedCount == 0 {
 		return nil, ItemNotFound
@@ -143,97 +422,195 @@ func (m *Manager) DeletePost(ctx context.Context, postId string) (*posts.Post, e
 	return post, nil
 }
 
-func (m *Manager) Upvote(postId string, userId int) (*posts.Post, error) {
-	return m.vote(postId, &posts.Vote{UserId: userId, Vote: 1})
+// canModerate reports whether user is allowed to manage content of the author
+func canModerate(user session.UserClaims, author posts.Author) bool {
+	return user.Id == author.ID || users.IsModerator(user.Role)
+}
+
+func (m *Manager) Upvote(ctx context.Context, postId string, userId int) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Upvote")
+	defer tracing.End(span, &err)
+	return m.vote(ctx, userId, "post", "up", func() (*posts.Post, error) {
+		return m.repo.Vote(ctx, postId, userId, 1, m.ranking)
+	})
+}
+
+func (m *Manager) Downvote(ctx context.Context, postId string, userId int) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Downvote")
+	defer tracing.End(span, &err)
+	return m.vote(ctx, userId, "post", "down", func() (*posts.Post, error) {
+		return m.repo.Vote(ctx, postId, userId, -1, m.ranking)
+	})
+}
+
+func (m *Manager) Unvote(ctx context.Context, postId string, userId int) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Unvote")
+	defer tracing.End(span, &err)
+	return m.vote(ctx, userId, "post", "un", func() (*posts.Post, error) {
+		return m.repo.Unvote(ctx, postId, userId, m.ranking)
+	})
+}
+
+func (m *Manager) UpvoteComment(ctx context.Context, postId, commentId string, userId int) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.UpvoteComment")
+	defer tracing.End(span, &err)
+	return m.vote(ctx, userId, "comment", "up", func() (*posts.Post, error) {
+		return m.repo.VoteComment(ctx, postId, commentId, userId, 1)
+	})
+}
+
+func (m *Manager) DownvoteComment(ctx context.Context, postId, commentId string, userId int) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.DownvoteComment")
+	defer tracing.End(span, &err)
+	return m.vote(ctx, userId, "comment", "down", func() (*posts.Post, error) {
+		return m.repo.VoteComment(ctx, postId, commentId, userId, -1)
+	})
 }
 
-func (m *Manager) Downvote(postId string, userId int) (*posts.Post, error) {
-	return m.vote(postId, &posts.Vote{UserId: userId, Vote: -1})
+func (m *Manager) UnvoteComment(ctx context.Context, postId, commentId string, userId int) (_ *posts.Post, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.UnvoteComment")
+	defer tracing.End(span, &err)
+	return m.vote(ctx, userId, "comment", "un", func() (*posts.Post, error) {
+		return m.repo.UnvoteComment(ctx, postId, commentId, userId)
+	})
 }
 
-func (m *Manager) Unvote(postId string, userId int) (*posts.Post, error) {
-	post, err := m.repo.GetById(postId)
+// vote runs repo vote operation, repeating the same vote changes nothing.
+// Target and vote label the votes metric.
+func (m *Manager) vote(ctx context.Context, userId int, target, vote string, record func() (*posts.Post, error)) (*posts.Post, error) {
+	post, err := record()
 	if err != nil {
-		return nil, err
+		log.Clog(ctx).Error("Cant record vote", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: "Cant record vote"}
 	}
 	if post == nil {
-		return post, ItemNotFound
+		return nil, ItemNotFound
 	}
+	metrics.Votes.WithLabelValues(target, vote).Inc()
+	return m.present(ctx, userId, post), nil
+}
 
-	var vote *posts.Vote
-	for _, v := range post.Votes {
-		if v.UserId == userId {
-			vote = v
+// Rerank recomputes stored ranks of all posts, it's needed after a ranker is changed.
+// Returns number of reranked posts.
+func (m *Manager) Rerank(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Rerank")
+	defer tracing.End(span, &err)
+	page, count := posts.Page{Limit: posts.MaxPageLimit}, 0
+	for {
+		items, err := m.repo.GetAll(ctx, page)
+		if err != nil {
+			log.Clog(ctx).Error("Cant fetch posts", log.Fields{"error": err.Error()})
+			return count, errors.InternalError{Details: err.Error()}
 		}
+		for _, item := range items {
+			// repo recomputes ranks from current rating, so votes recorded meanwhile aren't lost
+			if _, err = m.repo.Rerank(ctx, item.ID, m.ranking); err != nil {
+				log.Clog(ctx).Error("Cant rerank post", log.Fields{"id": item.ID, "error": err.Error()})
+				return count, errors.InternalError{Details: err.Error()}
+			}
+			count++
+		}
+		if len(items) < page.Limit {
+			return count, nil
+		}
+		page.After = posts.CursorOf(items[len(items)-1])
 	}
-	if vote == nil {
-		return post, nil
-	}
-	// recalculate statistics
-	if vote.Vote == 1 {
-		post.Upvotes -= 1
-		post.Score -= 1
-	} else {
-		post.Downvotes -= 1
-		post.Score += 1
-	}
-	post.UpvotePercentage = int(float32(post.Upvotes) / float32(post.Upvotes+post.Downvotes) * 100)
+}
 
-	_, err = m.repo.DeleteVote(postId, userId)
-	if err != nil {
-		return post, errors.InternalError{"Cant update post"}
+// RerankUnranked stores ranks of posts which have none, like posts created before ranks were stored,
+// such posts aren't listed by ranked sorts. Returns count of reranked posts.
+func (m *Manager) RerankUnranked(ctx context.Context) (_ int, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.RerankUnranked")
+	defer tracing.End(span, &err)
+	count := 0
+	// post left without ranks after rerank (e.g. by ranking without rankers) is not fetched again
+	seen := make(map[string]bool)
+	for {
+		ids, err := m.repo.Unranked(ctx, posts.MaxPageLimit)
+		if err != nil {
+			log.Clog(ctx).Error("Cant fetch unranked posts", log.Fields{"error": err.Error()})
+			return count, errors.InternalError{Details: err.Error()}
+		}
+		fresh := ids[:0]
+		for _, id := range ids {
+			if !seen[id] {
+				seen[id] = true
+				fresh = append(fresh, id)
+			}
+		}
+		if len(fresh) == 0 {
+			return count, nil
+		}
+		for _, id := range fresh {
+			if _, err = m.repo.Rerank(ctx, id, m.ranking); err != nil {
+				log.Clog(ctx).Error("Cant rerank post", log.Fields{"id": id, "error": err.Error()})
+				return count, errors.InternalError{Details: err.Error()}
+			}
+			count++
+		}
 	}
-	m.repo.UpdateStat(postId, post.UpvotePercentage, post.Score)
-	return post, nil
 }
 
-func (m *Manager) vote(postId string, vote *posts.Vote) (*posts.Post, error) {
-	post, err := m.repo.GetById(postId)
+// Voters returns all votes on the post and its comments, only moderators are allowed to see them
+func (m *Manager) Voters(ctx context.Context, postId string, user session.UserClaims) (_ []*posts.Vote, err error) {
+	ctx, span := tracing.Start(ctx, "posts.Manager.Voters")
+	defer tracing.End(span, &err)
+	if !users.IsModerator(user.Role) {
+		log.Clog(ctx).Info("Voters requested by not moderator", log.Fields{"postId": postId, "userId": user.Id})
+		return nil, errors.ForbiddenError{Action: "view voters"}
+	}
+	post, err := m.repo.GetById(ctx, postId)
 	if err != nil {
-		return nil, err
+		log.Clog(ctx).Error("Repo error during post fetching", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
 	if post == nil {
-		return post, ItemNotFound
+		return nil, ItemNotFound
 	}
-
-	var existingVote *posts.Vote
-	for _, v := range post.Votes {
-		if v.UserId == vote.UserId {
-			existingVote = v
-		}
+	votes, err := m.repo.Votes(ctx, postId)
+	if err != nil {
+		log.Clog(ctx).Error("Repo error during votes fetching", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
+	log.Clog(ctx).Info("Voters viewed", log.Fields{"postId": postId, "userId": user.Id})
+	return votes, nil
+}
 
-	// if existing vote is the same -> do nothing
-	if existingVote != nil && existingVote.Vote == vote.Vote {
-		log.Debug("Vote not changed")
-		return post, nil
-	}
+// present prepares post for response: fills votes of the user and arranges comments into threads
+func (m *Manager) present(ctx context.Context, userId int, post *posts.Post) *posts.Post {
+	m.setMyVotes(ctx, userId, post)
+	post.Comments = posts.BuildThread(post.Comments)
+	return post
+}
 
-	var delta int
-	if existingVote == nil {
-		delta = vote.Vote
-		post.Votes = append(post.Votes, vote)
-	} else {
-		// existing vote is opposite
-		// f.e. if we change vote from -1 to 1, we should increment score with 2
-		delta = 2
-		// we also change existing vote to return proper post data
-		existingVote.Vote = vote.Vote
+// setMyVotes fills votes of the user on the posts and their comments
+func (m *Manager) setMyVotes(ctx context.Context, userId int, items ...*posts.Post) {
+	if userId == 0 || len(items) == 0 {
+		return
 	}
-	// remove existing vote from stats, add new vote and recalculate percentage
-	if vote.Vote == 1 {
-		post.Upvotes += delta
-	} else {
-		post.Downvotes += delta
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.ID)
 	}
-	post.Score = post.Upvotes - post.Downvotes
-	post.UpvotePercentage = int(float32(post.Upvotes) / float32(post.Upvotes+post.Downvotes) * 100)
-
-	// now record/update vote and stats
-	_, err = m.repo.Vote(postId, vote)
+	votes, err := m.repo.UserVotes(ctx, userId, ids)
 	if err != nil {
-		return post, errors.InternalError{"Cant update post"}
+		// posts are still useful without user votes
+		log.Clog(ctx).Warn("Cant fetch user votes", log.Fields{"error": err.Error()})
+		return
 	}
-	m.repo.UpdateStat(postId, post.UpvotePercentage, post.Score)
-	return post, nil
+	for _, item := range items {
+		item.MyVote = votes[item.ID]
+		for _, comment := range item.Comments {
+			comment.MyVote = votes[comment.ID]
+		}
+	}
+}
+
+// viewerId returns id of the user requesting posts, zero for anonymous
+func viewerId(ctx context.Context) int {
+	sess := session.FromCtx(ctx)
+	if sess == nil {
+		return 0
+	}
+	return sess.User.Id
 }
@@ -0,0 +1,248 @@
+package usecase
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"golang-stepik-2022q1/reditclone/pkg/posts/repo"
+	"golang-stepik-2022q1/reditclone/pkg/session"
+	"golang-stepik-2022q1/reditclone/pkg/users"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCanModerate(t *testing.T) {
+	author := posts.Author{Username: "John", ID: 1}
+
+	for _, tt := range [...]struct {
+		name     string
+		user     session.UserClaims
+		expected bool
+	}{
+		{"Author", session.UserClaims{Username: "John", Id: 1, Role: users.RoleUser}, true},
+		{"Other user", session.UserClaims{Username: "Jane", Id: 2, Role: users.RoleUser}, false},
+		{"Moderator", session.UserClaims{Username: "Mod", Id: 3, Role: users.RoleModerator}, true},
+		{"Admin", session.UserClaims{Username: "Admin", Id: 4, Role: users.RoleAdmin}, true},
+		{"No role", session.UserClaims{Username: "Jane", Id: 2}, false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.expected, canModerate(tt.user, author))
+		})
+	}
+}
+
+type viewCounterStub struct{}
+
+func (viewCounterStub) View(ctx context.Context, postId, viewer string) (bool, error) {
+	return true, nil
+}
+
+var _ Repo = repo.NewMemRepo()
+var _ Repo = &repo.MongoRepo{}
+var _ Repo = &repo.SqlRepo{}
+
+func TestManager_MemRepo(t *testing.T) {
+	testManagerFlow(t, repo.NewMemRepo())
+	testRankedListing(t, repo.NewMemRepo())
+}
+
+func TestManager_SqliteRepo(t *testing.T) {
+	testManagerFlow(t, newSqliteRepo(t))
+	testRankedListing(t, newSqliteRepo(t))
+}
+
+// staleRepo returns post as it was read before, like to concurrent edit of the same post
+type staleRepo struct {
+	Repo
+	post *posts.Post
+}
+
+func (r staleRepo) GetById(ctx context.Context, postId string) (*posts.Post, error) {
+	return r.post, nil
+}
+
+func TestManager_ConcurrentEdit(t *testing.T) {
+	for name, postRepo := range map[string]Repo{"memory": repo.NewMemRepo(), "sqlite": newSqliteRepo(t)} {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			manager := NewManager(postRepo, viewCounterStub{})
+			author := session.UserClaims{Username: "John", Id: 1, Role: users.RoleUser}
+			post, err := manager.Create(ctx, &posts.PostIn{
+				Type: "text", Title: "Title", Category: "music", Text: "text",
+				Author: posts.Author{Username: author.Username, ID: author.Id},
+			})
+			require.NoError(t, err)
+			stale, err := postRepo.GetById(ctx, post.ID)
+			require.NoError(t, err)
+
+			_, err = manager.Edit(ctx, post.ID, &posts.PostEditIn{Title: "First edit", Text: "first"}, author)
+			require.NoError(t, err)
+			_, err = NewManager(staleRepo{postRepo, stale}, viewCounterStub{}).
+				Edit(ctx, post.ID, &posts.PostEditIn{Title: "Second edit", Text: "second"}, author)
+			assert.Equal(t, EditConflictError, err)
+
+			stored, err := postRepo.GetById(ctx, post.ID)
+			require.NoError(t, err)
+			assert.Equal(t, "First edit", stored.Title)
+			assert.Len(t, stored.Revisions, 1)
+		})
+	}
+}
+
+func newSqliteRepo(t *testing.T) *repo.SqlRepo {
+	conn, err := db.NewSqlite(filepath.Join(t.TempDir(), "reddit.db"))
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	for _, name := range []string{"John", "Jane"} {
+		_, err := conn.Exec(`INSERT INTO users (name, pass_hash) VALUES ($1, '')`, name)
+		assert.NoError(t, err)
+	}
+	return repo.NewSqliteRepo(conn)
+}
+
+// testRankedListing pages top feed ordered by stored ranks, votes between pages don't shift other posts
+func testRankedListing(t *testing.T, postRepo Repo) {
+	ctx := context.Background()
+	manager := NewManager(postRepo, viewCounterStub{})
+	ids := make([]string, 0, 3)
+	for _, title := range []string{"First", "Second", "Third"} {
+		post, err := manager.Create(ctx, &posts.PostIn{
+			Type: "text", Title: title, Category: "music", Text: "text",
+			Author: posts.Author{Username: "John", ID: 1},
+		})
+		require.NoError(t, err)
+		ids = append(ids, post.ID)
+	}
+	_, err := manager.Upvote(ctx, ids[1], 1)
+	require.NoError(t, err)
+	_, err = manager.Downvote(ctx, ids[2], 1)
+	require.NoError(t, err)
+
+	listing := posts.Listing{Sort: posts.SortTop, Page: posts.Page{Limit: 1}}
+	page, err := manager.GetAll(ctx, listing)
+	require.NoError(t, err)
+	require.Len(t, page.Posts, 1)
+	assert.Equal(t, ids[1], page.Posts[0].ID)
+	require.NotEmpty(t, page.NextCursor)
+
+	// the first post overtakes the listed one, other posts aren't shifted by it
+	_, err = manager.Upvote(ctx, ids[0], 1)
+	require.NoError(t, err)
+	_, err = manager.Upvote(ctx, ids[0], 2)
+	require.NoError(t, err)
+
+	listing.Page.Limit = 10
+	listing.Page.After, err = posts.DecodeCursor(page.NextCursor)
+	require.NoError(t, err)
+	page, err = manager.GetAll(ctx, listing)
+	require.NoError(t, err)
+	require.Len(t, page.Posts, 1)
+	assert.Equal(t, ids[2], page.Posts[0].ID)
+	assert.Empty(t, page.NextCursor)
+
+	_, err = manager.GetAll(ctx, posts.Listing{Sort: posts.SortHot, Page: listing.Page})
+	assert.Equal(t, posts.InvalidCursorError, err)
+
+	// rising feed is ranked in memory, the next page keeps time of the first one
+	rising := posts.Listing{Sort: posts.SortRising, Page: posts.Page{Limit: 2}}
+	page, err = manager.GetAll(ctx, rising)
+	require.NoError(t, err)
+	require.Len(t, page.Posts, 2)
+	assert.Equal(t, ids[0], page.Posts[0].ID)
+	rising.Page.After, err = posts.DecodeCursor(page.NextCursor)
+	require.NoError(t, err)
+	page, err = manager.GetAll(ctx, rising)
+	require.NoError(t, err)
+	require.Len(t, page.Posts, 1)
+	assert.Equal(t, ids[2], page.Posts[0].ID)
+
+	reranked, err := manager.Rerank(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, reranked)
+
+	// post stored before ranks were is listed by ranked sorts once its ranks are stored on startup
+	legacy, err := postRepo.Add(ctx, &posts.Post{
+		ID: uuid.New().String(), Type: "text", Title: "Legacy", Category: "music", Text: "text",
+		Author: posts.Author{Username: "John", ID: 1}, Comments: []*posts.Comment{}, Created: time.Now(),
+	})
+	require.NoError(t, err)
+	reranked, err = manager.RerankUnranked(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, reranked)
+	page, err = manager.GetAll(ctx, posts.Listing{Sort: posts.SortTop, Page: posts.Page{Limit: 10}})
+	require.NoError(t, err)
+	listed := make([]string, 0, len(page.Posts))
+	for _, item := range page.Posts {
+		listed = append(listed, item.ID)
+	}
+	assert.Contains(t, listed, legacy.ID)
+	reranked, err = manager.RerankUnranked(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, reranked)
+}
+
+// testManagerFlow runs post lifecycle, returned posts are threaded and must not change the stored ones
+func testManagerFlow(t *testing.T, postRepo Repo) {
+	ctx := context.Background()
+	manager := NewManager(postRepo, viewCounterStub{})
+	author := session.UserClaims{Username: "John", Id: 1, Role: users.RoleUser}
+	other := session.UserClaims{Username: "Jane", Id: 2, Role: users.RoleUser}
+
+	post, err := manager.Create(ctx, &posts.PostIn{
+		Type: "text", Title: "Title", Category: "music", Text: "text",
+		Author: posts.Author{Username: author.Username, ID: author.Id},
+	})
+	assert.NoError(t, err)
+
+	post, err = manager.CreateComment(ctx, post.ID, &posts.CommentIn{Comment: "parent", Author: posts.Author{ID: other.Id}})
+	assert.NoError(t, err)
+	parentId := post.Comments[0].ID
+	post, err = manager.CreateComment(ctx, post.ID, &posts.CommentIn{Comment: "reply", ParentID: parentId, Author: posts.Author{ID: author.Id}})
+	assert.NoError(t, err)
+	assert.Len(t, post.Comments, 1)
+	assert.Len(t, post.Comments[0].Replies, 1)
+
+	_, err = manager.Edit(ctx, post.ID, &posts.PostEditIn{Title: "New title", Text: "new text"}, other)
+	assert.IsType(t, errors.ForbiddenError{}, err)
+	edited, err := manager.Edit(ctx, post.ID, &posts.PostEditIn{Title: "New title", Text: "new text"}, author)
+	assert.NoError(t, err)
+	assert.Equal(t, "New title", edited.Title)
+	assert.Len(t, edited.Comments, 1)
+	assert.Len(t, edited.Comments[0].Replies, 1)
+
+	_, err = manager.Upvote(ctx, post.ID, other.Id)
+	assert.NoError(t, err)
+	_, err = manager.DownvoteComment(ctx, post.ID, parentId, author.Id)
+	assert.NoError(t, err)
+
+	// comment with reply becomes tombstone
+	post, err = manager.DeleteComment(ctx, post.ID, parentId, other)
+	assert.NoError(t, err)
+
+	stored, err := manager.Get(ctx, post.ID, "u:2")
+	assert.NoError(t, err)
+	assert.Equal(t, "New title", stored.Title)
+	assert.Equal(t, 1, stored.Score)
+	assert.Len(t, stored.Comments, 1)
+	assert.True(t, stored.Comments[0].Deleted)
+	assert.Equal(t, posts.DeletedCommentBody, stored.Comments[0].Body)
+	assert.Equal(t, -1, stored.Comments[0].Score)
+	assert.Len(t, stored.Comments[0].Replies, 1)
+
+	revisions, err := manager.Revisions(ctx, post.ID, author)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 1)
+	assert.Equal(t, "Title", revisions[0].Title)
+
+	_, err = manager.DeletePost(ctx, post.ID, other)
+	assert.IsType(t, errors.ForbiddenError{}, err)
+	_, err = manager.DeletePost(ctx, post.ID, author)
+	assert.NoError(t, err)
+	_, err = manager.Get(ctx, post.ID, "u:2")
+	assert.Equal(t, ItemNotFound, err)
+}
@@ -0,0 +1,128 @@
+package usecase
+
+import (
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"math"
+	"sort"
+	"time"
+)
+
+// Ranker weights posts of ranked feeds, posts with bigger rank go first.
+// Rank depends on the post only: ranks are stored with posts and updated on every vote,
+// so feeds are ordered and paged by the datastore. Stored ranks are recomputed by rerank command
+// after a ranker is changed.
+type Ranker interface {
+	Rank(post *posts.Post) float64
+}
+
+type RankerFunc func(post *posts.Post) float64
+
+func (f RankerFunc) Rank(post *posts.Post) float64 {
+	return f(post)
+}
+
+// WindowRanker ranks posts created within Window before now, its ranks change with time and can't be stored.
+// Feed is ranked in memory among at most maxWindowCandidates newest posts of the window.
+type WindowRanker interface {
+	Rank(post *posts.Post, now time.Time) float64
+	Window() time.Duration
+}
+
+// maxWindowCandidates bounds number of posts fetched for windowed feed
+const maxWindowCandidates = 1000
+
+// epoch of hot rank, any constant works as long as it's not changed
+var hotEpoch = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// HotRanker is reddit "hot" formula: order of magnitude of score
+// plus time bonus, so 10 times bigger score is worth 12.5 hours of age.
+var HotRanker = RankerFunc(func(post *posts.Post) float64 {
+	order := math.Log10(math.Max(math.Abs(float64(post.Score)), 1))
+	sign := 0.0
+	if post.Score > 0 {
+		sign = 1
+	} else if post.Score < 0 {
+		sign = -1
+	}
+	seconds := post.Created.Sub(hotEpoch).Seconds()
+	return sign*order + seconds/45000
+})
+
+var TopRanker = RankerFunc(func(post *posts.Post) float64 {
+	return float64(post.Score)
+})
+
+// ControversialRanker favours posts with many votes split evenly between up and down
+var ControversialRanker = RankerFunc(func(post *posts.Post) float64 {
+	if post.Upvotes <= 0 || post.Downvotes <= 0 {
+		return 0
+	}
+	magnitude := float64(post.Upvotes + post.Downvotes)
+	balance := float64(post.Downvotes) / float64(post.Upvotes)
+	if post.Upvotes < post.Downvotes {
+		balance = float64(post.Upvotes) / float64(post.Downvotes)
+	}
+	return math.Pow(magnitude, balance)
+})
+
+const risingWindow = 24 * time.Hour
+
+type risingRanker struct{}
+
+// Rank is score gained per hour of age with gravity, so fresh posts gaining votes fast go first
+func (risingRanker) Rank(post *posts.Post, now time.Time) float64 {
+	hours := now.Sub(post.Created).Hours()
+	return float64(post.Score) / math.Pow(math.Max(hours, 0)+2, 1.5)
+}
+
+func (risingRanker) Window() time.Duration {
+	return risingWindow
+}
+
+var RisingRanker WindowRanker = risingRanker{}
+
+func DefaultRankers() map[string]Ranker {
+	return map[string]Ranker{
+		posts.SortHot:           HotRanker,
+		posts.SortTop:           TopRanker,
+		posts.SortControversial: ControversialRanker,
+	}
+}
+
+func DefaultWindowRankers() map[string]WindowRanker {
+	return map[string]WindowRanker{
+		posts.SortRising: RisingRanker,
+	}
+}
+
+// rank sorts items by rank descending, then by id descending like datastore does for stored ranks
+func rank(items []*posts.Post, rankOf func(*posts.Post) float64) map[*posts.Post]float64 {
+	ranks := make(map[*posts.Post]float64, len(items))
+	for _, item := range items {
+		ranks[item] = rankOf(item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if ranks[items[i]] == ranks[items[j]] {
+			return items[i].ID > items[j].ID
+		}
+		return ranks[items[i]] > ranks[items[j]]
+	})
+	return ranks
+}
+
+// periodStart returns time top feed period starts at, zero time means no bound
+func periodStart(period string, now time.Time) (time.Time, error) {
+	switch period {
+	case posts.PeriodDay, "":
+		return now.AddDate(0, 0, -1), nil
+	case posts.PeriodWeek:
+		return now.AddDate(0, 0, -7), nil
+	case posts.PeriodMonth:
+		return now.AddDate(0, -1, 0), nil
+	case posts.PeriodYear:
+		return now.AddDate(-1, 0, 0), nil
+	case posts.PeriodAll:
+		return time.Time{}, nil
+	}
+	return time.Time{}, posts.UnknownPeriodError
+}
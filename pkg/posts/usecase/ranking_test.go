@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"github.com/stretchr/testify/assert"
+	"golang-stepik-2022q1/reditclone/pkg/posts"
+	"testing"
+	"time"
+)
+
+func TestRank(t *testing.T) {
+	now := time.Now()
+	fresh := &posts.Post{ID: "fresh", Created: now.Add(-time.Hour), Rating: posts.Rating{Score: 10, Upvotes: 10}}
+	old := &posts.Post{ID: "old", Created: now.AddDate(0, 0, -3), Rating: posts.Rating{Score: 100, Upvotes: 100}}
+	disputed := &posts.Post{ID: "disputed", Created: now.Add(-2 * time.Hour), Rating: posts.Rating{Score: 0, Upvotes: 50, Downvotes: 50}}
+	disliked := &posts.Post{ID: "disliked", Created: now.Add(-30 * time.Minute), Rating: posts.Rating{Score: -5, Upvotes: 1, Downvotes: 6}}
+
+	rising := func(post *posts.Post) float64 { return RisingRanker.Rank(post, now) }
+
+	for _, tt := range [...]struct {
+		name     string
+		rankOf   func(*posts.Post) float64
+		expected []string
+	}{
+		{"Hot", HotRanker, []string{"fresh", "disputed", "disliked", "old"}},
+		{"Top", TopRanker, []string{"old", "fresh", "disputed", "disliked"}},
+		// ties are resolved by id descending, like stored ranks are listed
+		{"Controversial", ControversialRanker, []string{"disputed", "disliked", "old", "fresh"}},
+		{"Rising", rising, []string{"fresh", "old", "disputed", "disliked"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			items := []*posts.Post{old, disliked, fresh, disputed}
+			rank(items, tt.rankOf)
+			ids := make([]string, 0, len(items))
+			for _, item := range items {
+				ids = append(ids, item.ID)
+			}
+			assert.Equal(t, tt.expected, ids)
+		})
+	}
+}
+
+func TestPeriodStart(t *testing.T) {
+	now := time.Date(2022, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	for _, tt := range [...]struct {
+		period      string
+		expected    time.Time
+		expectedErr error
+	}{
+		{"", now.AddDate(0, 0, -1), nil},
+		{posts.PeriodDay, now.AddDate(0, 0, -1), nil},
+		{posts.PeriodWeek, now.AddDate(0, 0, -7), nil},
+		{posts.PeriodMonth, now.AddDate(0, -1, 0), nil},
+		{posts.PeriodYear, now.AddDate(-1, 0, 0), nil},
+		{posts.PeriodAll, time.Time{}, nil},
+		{"century", time.Time{}, posts.UnknownPeriodError},
+	} {
+		t.Run(tt.period, func(t *testing.T) {
+			since, err := periodStart(tt.period, now)
+			assert.Equal(t, tt.expectedErr, err)
+			assert.Equal(t, tt.expected, since)
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+const ReporterFile = "file"
+
+// Report describes panic recovered while serving a request
+type Report struct {
+	Time      time.Time `json:"time"`
+	RequestID string    `json:"request_id"`
+	Method    string    `json:"method"`
+	Url       string    `json:"url"`
+	Route     string    `json:"route"`
+	Panic     string    `json:"panic"`
+	Stack     string    `json:"stack"`
+}
+
+// Reporter sends reports to error tracking, e.g. Sentry client can be plugged in by implementing it
+type Reporter interface {
+	Report(ctx context.Context, report *Report) error
+}
+
+// New returns reporter of given kind, empty kind means panics are only logged.
+// Returned close flushes and releases reporter resources.
+func New(kind, path string) (Reporter, func() error, error) {
+	switch kind {
+	case "":
+		return Nop{}, func() error { return nil }, nil
+	case ReporterFile:
+		reporter, err := NewFileReporter(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		return reporter, reporter.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown error reporter %q", kind)
+	}
+}
+
+type Nop struct{}
+
+func (Nop) Report(context.Context, *Report) error {
+	return nil
+}
+
+// FileReporter appends reports as JSON lines to a file, it's meant for local development
+type FileReporter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFileReporter(path string) (*FileReporter, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileReporter{file: file}, nil
+}
+
+func (r *FileReporter) Report(_ context.Context, report *Report) error {
+	line, err := json.Marshal(report)
+	if err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err = r.file.Write(append(line, '\n'))
+	return err
+}
+
+func (r *FileReporter) Close() error {
+	return r.file.Close()
+}
@@ -0,0 +1,37 @@
+package reporting
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileReporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "errors.json")
+	reporter, closeReporter, err := New(ReporterFile, path)
+	require.NoError(t, err)
+
+	for _, id := range []string{"a", "b"} {
+		require.NoError(t, reporter.Report(context.Background(), &Report{RequestID: id, Panic: "boom"}))
+	}
+	require.NoError(t, closeReporter())
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 2)
+	report := &Report{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), report))
+	assert.Equal(t, "b", report.RequestID)
+	assert.Equal(t, "boom", report.Panic)
+}
+
+func TestNew_Unknown(t *testing.T) {
+	_, _, err := New("sentry", "")
+	assert.Error(t, err)
+}
@@ -2,9 +2,13 @@ package repo
 
 import (
 	"context"
+	"github.com/go-redis/redis/v8"
 	"golang-stepik-2022q1/reditclone/pkg/db"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
 	"golang-stepik-2022q1/reditclone/pkg/session"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"time"
 )
 
 type RedisRepo struct {
@@ -15,9 +19,14 @@ func NewRedis(cli db.IRedisClient) *RedisRepo {
 	return &RedisRepo{cli}
 }
 
-func (r *RedisRepo) Set(ctx context.Context, sessionId session.SessionId) error {
+func (r *RedisRepo) Set(ctx context.Context, sessionId session.SessionId) (err error) {
+	defer metrics.ObserveRepo(metrics.Redis, "sessions.Set", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Redis, "sessions.Set")
+	defer tracing.End(span, &err)
 	key := sessionKey(sessionId)
-	err := r.client.Set(ctx, key, "", 0).Err()
+	opCtx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	err = r.client.Set(opCtx, key, "", 0).Err()
 	if err != nil {
 		return err
 	}
@@ -26,9 +35,17 @@ func (r *RedisRepo) Set(ctx context.Context, sessionId session.SessionId) error
 }
 
 func (r *RedisRepo) CheckExists(ctx context.Context, sessionId session.SessionId) bool {
+	defer metrics.ObserveRepo(metrics.Redis, "sessions.CheckExists", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Redis, "sessions.CheckExists")
+	defer span.End()
 	key := sessionKey(sessionId)
-	_, err := r.client.Get(ctx, key).Result()
+	opCtx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	_, err := r.client.Get(opCtx, key).Result()
 	if err != nil {
+		if err != redis.Nil {
+			tracing.Fail(span, err)
+		}
 		log.Clog(ctx).Debug("Session not found", log.Fields{"key": key, "err": err.Error()})
 		return false
 	}
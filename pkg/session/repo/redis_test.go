@@ -37,7 +37,7 @@ func TestRedisRepo_Set(t *testing.T) {
 			name: "OK",
 			setup: func(cli *db.MockIRedisClient, cmd *db.MockIRedisStatusCmd) {
 				gomock.InOrder(
-					clientMock.EXPECT().Set(ctx, sessionKey, val, ttl).Return(cmdMock),
+					clientMock.EXPECT().Set(gomock.Any(), sessionKey, val, ttl).Return(cmdMock),
 					cmdMock.EXPECT().Err().Return(nil),
 				)
 			},
@@ -47,7 +47,7 @@ func TestRedisRepo_Set(t *testing.T) {
 			name: "Redis error",
 			setup: func(cli *db.MockIRedisClient, cmd *db.MockIRedisStatusCmd) {
 				gomock.InOrder(
-					clientMock.EXPECT().Set(ctx, sessionKey, val, ttl).Return(cmdMock),
+					clientMock.EXPECT().Set(gomock.Any(), sessionKey, val, ttl).Return(cmdMock),
 					cmdMock.EXPECT().Err().Return(unexpectedErr),
 				)
 			},
@@ -86,7 +86,7 @@ func TestRedisRepo_CheckExists(t *testing.T) {
 			name: "OK",
 			setup: func(cli *db.MockIRedisClient, cmd *db.MockIRedisStatusCmd) {
 				gomock.InOrder(
-					clientMock.EXPECT().Get(ctx, sessionKey).Return(cmdMock),
+					clientMock.EXPECT().Get(gomock.Any(), sessionKey).Return(cmdMock),
 					cmdMock.EXPECT().Result().Return(val, nil),
 				)
 			},
@@ -96,7 +96,7 @@ func TestRedisRepo_CheckExists(t *testing.T) {
 			name: "Redis error",
 			setup: func(cli *db.MockIRedisClient, cmd *db.MockIRedisStatusCmd) {
 				gomock.InOrder(
-					clientMock.EXPECT().Get(ctx, sessionKey).Return(cmdMock),
+					clientMock.EXPECT().Get(gomock.Any(), sessionKey).Return(cmdMock),
 					cmdMock.EXPECT().Result().Return(val, unexpectedErr),
 				)
 			},
@@ -106,7 +106,7 @@ func TestRedisRepo_CheckExists(t *testing.T) {
 			name: "Not found",
 			setup: func(cli *db.MockIRedisClient, cmd *db.MockIRedisStatusCmd) {
 				gomock.InOrder(
-					clientMock.EXPECT().Get(ctx, sessionKey).Return(cmdMock),
+					clientMock.EXPECT().Get(gomock.Any(), sessionKey).Return(cmdMock),
 					cmdMock.EXPECT().Result().Return(val, redis.ErrNil),
 				)
 			},
@@ -0,0 +1,54 @@
+package repo
+
+import (
+	"context"
+	"database/sql"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
+	"golang-stepik-2022q1/reditclone/pkg/session"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"time"
+)
+
+// SqlRepo keeps sessions in sessions table, it's used in embedded mode instead of Redis
+type SqlRepo struct {
+	db *sql.DB
+}
+
+func NewSql(db *sql.DB) *SqlRepo {
+	return &SqlRepo{db: db}
+}
+
+func (r *SqlRepo) Set(ctx context.Context, sessionId session.SessionId) (err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "sessions.Set", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "sessions.Set")
+	defer tracing.End(span, &err)
+	opCtx, cancel := db.WriteContext(ctx)
+	defer cancel()
+	_, err = r.db.ExecContext(opCtx, `INSERT INTO sessions (id) VALUES ($1)`, string(sessionId))
+	if err != nil {
+		return err
+	}
+	log.Clog(ctx).Debug("Session set", log.Fields{"id": sessionId})
+	return nil
+}
+
+func (r *SqlRepo) CheckExists(ctx context.Context, sessionId session.SessionId) bool {
+	defer metrics.ObserveRepo(metrics.Sql, "sessions.CheckExists", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "sessions.CheckExists")
+	defer span.End()
+	var found int
+	opCtx, cancel := db.ReadContext(ctx)
+	defer cancel()
+	err := r.db.QueryRowContext(opCtx, `SELECT 1 FROM sessions WHERE id = $1`, string(sessionId)).Scan(&found)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			tracing.Fail(span, err)
+		}
+		log.Clog(ctx).Debug("Session not found", log.Fields{"id": sessionId, "err": err.Error()})
+		return false
+	}
+	log.Clog(ctx).Debug("Session found", log.Fields{"id": sessionId})
+	return true
+}
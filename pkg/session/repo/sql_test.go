@@ -0,0 +1,25 @@
+package repo
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/session"
+	"path/filepath"
+	"testing"
+)
+
+func TestSqlRepo(t *testing.T) {
+	ctx := context.Background()
+	conn, err := db.NewSqlite(filepath.Join(t.TempDir(), "reddit.db"))
+	require.NoError(t, err)
+	defer conn.Close()
+	repo := NewSql(conn)
+
+	sessionId := session.SessionId("sessionID")
+	assert.False(t, repo.CheckExists(ctx, sessionId))
+	assert.NoError(t, repo.Set(ctx, sessionId))
+	assert.True(t, repo.CheckExists(ctx, sessionId))
+	assert.False(t, repo.CheckExists(ctx, session.SessionId("other")))
+}
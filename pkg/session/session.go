@@ -10,6 +10,7 @@ type SessionId string
 type UserClaims struct {
 	Username string `json:"username"`
 	Id       int    `json:"id"`
+	Role     string `json:"role,omitempty"`
 }
 
 type Session struct {
@@ -1,9 +1,9 @@
 package usecase
 
-import "errors"
+import "golang-stepik-2022q1/reditclone/pkg/errors"
 
 var (
-	InvalidTokenErr = errors.New("Token invalid")
-	ExpiredTokenErr = errors.New("Token expired")
-	SessionNotFound = errors.New("Session not found")
+	InvalidTokenErr = errors.UnauthenticatedError{Details: "Token invalid"}
+	ExpiredTokenErr = errors.UnauthenticatedError{Details: "Token expired"}
+	SessionNotFound = errors.UnauthenticatedError{Details: "Session not found"}
 )
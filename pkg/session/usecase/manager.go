@@ -7,7 +7,9 @@ import (
 	"golang-stepik-2022q1/reditclone/config"
 	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
 	"golang-stepik-2022q1/reditclone/pkg/session"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
 	"golang-stepik-2022q1/reditclone/pkg/users"
 	"time"
 )
@@ -25,28 +27,33 @@ func NewManager(repo Repo) *Manager {
 	return &Manager{repo}
 }
 
-func (m *Manager) IssueToken(ctx context.Context, u *users.User) (string, error) {
+func (m *Manager) IssueToken(ctx context.Context, u *users.User) (_ string, err error) {
+	ctx, span := tracing.Start(ctx, "session.Manager.IssueToken")
+	defer tracing.End(span, &err)
 	sess := &session.Session{
 		Id:   session.SessionId(uuid.New().String()),
-		User: session.UserClaims{u.Name, u.Id},
+		User: session.UserClaims{Username: u.Name, Id: u.Id, Role: u.Role},
 		Iat:  time.Now().Unix(),
 		Exp:  expDate().Unix(),
 	}
-	err := m.repo.Set(ctx, sess.Id)
+	err = m.repo.Set(ctx, sess.Id)
 	if err != nil {
 		log.Clog(ctx).Error("Error during session creation", log.Fields{"error": err.Error()})
-		return "", errors.InternalError{"Error during session creation"}
+		return "", errors.InternalError{Details: "Error during session creation"}
 	}
 	tokenString, err := generateToken(sess)
 	if err != nil {
 		detail := "Error during jwt token generation"
 		log.Clog(ctx).Error(detail, log.Fields{"error": err.Error()})
-		return "", errors.InternalError{detail}
+		return "", errors.InternalError{Details: detail}
 	}
+	metrics.SessionsIssued.Inc()
 	return tokenString, nil
 }
 
-func (m *Manager) Check(ctx context.Context, token string) (*session.Session, error) {
+func (m *Manager) Check(ctx context.Context, token string) (_ *session.Session, err error) {
+	ctx, span := tracing.Start(ctx, "session.Manager.Check")
+	defer tracing.End(span, &err)
 	sess, err := loadSession(token)
 	if err != nil {
 		return nil, err
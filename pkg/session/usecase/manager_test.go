@@ -20,7 +20,7 @@ func TestManager_IssueToken(t *testing.T) {
 	ctx := context.Background()
 	user := &users.User{Id: 123, Name: "John"}
 
-	st.EXPECT().Set(ctx, gomock.AssignableToTypeOf(session.SessionId(""))).Return(nil)
+	st.EXPECT().Set(gomock.Any(), gomock.AssignableToTypeOf(session.SessionId(""))).Return(nil)
 
 	token, err := manager.IssueToken(ctx, user)
 	assert.Equal(t, nil, err)
@@ -0,0 +1,130 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang-stepik-2022q1/reditclone/config"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"os"
+)
+
+const (
+	ServiceName = "reditclone"
+
+	ExporterOtlp = "otlp"
+	ExporterFile = "file"
+)
+
+// Setup installs global tracer provider and W3C trace context propagator.
+// Spans are created even without exporter, so incoming trace ids still reach logs.
+// Returned shutdown flushes buffered spans.
+func Setup(ctx context.Context) (func(ctx context.Context) error, error) {
+	exporter, closeExporter, err := newExporter(ctx, config.Cfg.TracingExporter)
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(ctx,
+		resource.WithAttributes(semconv.ServiceNameKey.String(ServiceName)),
+		resource.WithFromEnv(),
+		resource.WithTelemetrySDK(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := []sdktrace.TracerProviderOption{
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(config.Cfg.TracingSampleRatio))),
+	}
+	if exporter != nil {
+		opts = append(opts, sdktrace.WithBatcher(exporter))
+	}
+	provider := sdktrace.NewTracerProvider(opts...)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if closeErr := closeExporter(); err == nil {
+			err = closeErr
+		}
+		return err
+	}, nil
+}
+
+func newExporter(ctx context.Context, kind string) (sdktrace.SpanExporter, func() error, error) {
+	noClose := func() error { return nil }
+	switch kind {
+	case "":
+		return nil, noClose, nil
+	case ExporterOtlp:
+		exporter, err := otlptracehttp.New(ctx)
+		return exporter, noClose, err
+	case ExporterFile:
+		file, err := os.OpenFile(config.Cfg.TracingFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err := stdouttrace.New(stdouttrace.WithWriter(file))
+		if err != nil {
+			file.Close()
+			return nil, nil, err
+		}
+		log.Info("Spans are written to file", log.Fields{"path": config.Cfg.TracingFile})
+		return exporter, file.Close, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q", kind)
+	}
+}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(ServiceName)
+}
+
+// Start starts span of the operation, like "posts.Manager.Upvote"
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// StartRepo starts client span of the repo operation on the datastore (see metrics datastores)
+func StartRepo(ctx context.Context, store, op string) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, op,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemKey.String(store), semconv.DBOperationKey.String(op)),
+	)
+}
+
+// End ends span of the operation returning *err. Internal error is recorded on the span and fails it,
+// errors caused by the request (like validation or not found ones) are the expected outcome, not failures.
+func End(span trace.Span, err *error) {
+	if *err != nil && errors.CodeOf(*err) == errors.CodeInternal {
+		Fail(span, *err)
+	}
+	span.End()
+}
+
+// Fail records err on the span and marks the span failed
+func Fail(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// TraceId returns id of the trace ctx belongs to, empty when there is no trace
+func TraceId(ctx context.Context) string {
+	sc := trace.SpanContextFromContext(ctx)
+	if !sc.HasTraceID() {
+		return ""
+	}
+	return sc.TraceID().String()
+}
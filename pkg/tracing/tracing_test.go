@@ -0,0 +1,38 @@
+package tracing
+
+import (
+	"context"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
+	"testing"
+)
+
+func TestEnd(t *testing.T) {
+	for _, tt := range [...]struct {
+		name     string
+		err      error
+		expected codes.Code
+	}{
+		{"No error", nil, codes.Unset},
+		{"Internal error", errors.InternalError{Details: "connection refused"}, codes.Error},
+		{"Request error", errors.NotFoundError{Resource: "Item"}, codes.Unset},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			recorder := tracetest.NewSpanRecorder()
+			provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			_, span := provider.Tracer(ServiceName).Start(context.Background(), "op")
+
+			err := tt.err
+			End(span, &err)
+
+			ended := recorder.Ended()
+			require.Len(t, ended, 1)
+			assert.Equal(t, tt.expected, ended[0].Status().Code)
+			assert.Equal(t, tt.expected == codes.Error, len(ended[0].Events()) == 1)
+		})
+	}
+}
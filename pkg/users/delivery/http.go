@@ -2,7 +2,9 @@ package delivery
 
 import (
 	"encoding/json"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
 	sessionUC "golang-stepik-2022q1/reditclone/pkg/session/usecase"
 	"golang-stepik-2022q1/reditclone/pkg/users"
 	"golang-stepik-2022q1/reditclone/pkg/users/usecase"
@@ -27,26 +29,21 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 
 	userIn, err := http_utils.FromBody[users.UserIn](r)
 	if err != nil {
-		log.Clog(ctx).Error("Marshaling error")
-		http_utils.HttpError(w, "Marshaling error", http.StatusInternalServerError)
+		log.Clog(ctx).Info("Malformed register request", log.Fields{"error": err.Error()})
+		http_utils.HttpError(w, err)
 		return
 	}
 
 	user, err := h.manager.Create(ctx, userIn)
-	if err == usecase.UserExistsError {
-		http_utils.HttpError(w, "UserId exist", http.StatusBadRequest)
-		return
-	}
 	if err != nil {
-		log.Clog(ctx).Error("Unexpected error during user creation", log.Fields{"err": err.Error()})
-		http_utils.HttpError(w, err.Error(), http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 
 	token, err := h.sessionManager.IssueToken(r.Context(), user)
 	if err != nil {
 		log.Clog(ctx).Error("Cant issue token", log.Fields{"err": err.Error()})
-		http_utils.HttpError(w, err.Error(), http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
 
@@ -54,13 +51,13 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 	resp, err := json.Marshal(out)
 	if err != nil {
 		log.Clog(ctx).Error("Marshaling error")
-		http_utils.HttpError(w, "Marshaling error", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Marshaling error"})
 		return
 	}
 	_, err = w.Write(resp)
 	if err != nil {
 		log.Clog(ctx).Error("Cant write response")
-		http_utils.HttpError(w, "Cant write response", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant write response"})
 		return
 	}
 }
@@ -68,37 +65,34 @@ func (h *Handler) Register(w http.ResponseWriter, r *http.Request) {
 func (h *Handler) Login(w http.ResponseWriter, r *http.Request) {
 	in, err := http_utils.FromBody[LoginReq](r)
 	if err != nil {
-		log.Rlog(r).Error("Marshaling error")
-		http_utils.HttpError(w, "Marshaling error", http.StatusInternalServerError)
-		return
-	}
-
-	user, err := h.manager.GetByName(r.Context(), in.Username)
-
-	if user == nil {
-		http_utils.HttpError(w, "UserId not found", http.StatusUnauthorized)
+		log.Rlog(r).Info("Malformed login request", log.Fields{"error": err.Error()})
+		http_utils.HttpError(w, err)
 		return
 	}
 
-	passValid := usecase.CheckPassword(user.PassHash, in.Password)
-	if !passValid {
-		http_utils.HttpError(w, "invalid password", http.StatusUnauthorized)
+	user, err := h.manager.Authenticate(r.Context(), in.Username, in.Password)
+	if err != nil {
+		if errors.CodeOf(err) == errors.CodeUnauthenticated {
+			metrics.FailedLogins.Inc()
+		}
+		http_utils.HttpError(w, err)
 		return
 	}
 
 	token, err := h.sessionManager.IssueToken(r.Context(), user)
 	if err != nil {
-		http_utils.HttpError(w, err.Error(), http.StatusInternalServerError)
+		http_utils.HttpError(w, err)
 		return
 	}
+	metrics.Logins.Inc()
 	out := &LoginResp{token}
 	resp, err := json.Marshal(out)
 	if err != nil {
-		http_utils.HttpError(w, "Marshaling error", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Marshaling error"})
 		return
 	}
 	_, err = w.Write(resp)
 	if err != nil {
-		http_utils.HttpError(w, "Cant write response", http.StatusInternalServerError)
+		http_utils.HttpError(w, errors.InternalError{Details: "Cant write response"})
 	}
 }
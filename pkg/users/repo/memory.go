@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"context"
 	"golang-stepik-2022q1/reditclone/pkg/users"
 	"sync"
 )
@@ -15,14 +16,14 @@ func NewMemRepo() *MemRepo {
 	return &MemRepo{items: items}
 }
 
-func (r *MemRepo) Add(u *users.User) (*users.User, error) {
+func (r *MemRepo) Add(ctx context.Context, u *users.User) (*users.User, error) {
 	r.Lock()
 	defer r.Unlock()
 	r.items = append(r.items, u)
 	return u, nil
 }
 
-func (r *MemRepo) GetByName(val string) (*users.User, error) {
+func (r *MemRepo) GetByName(ctx context.Context, val string) (*users.User, error) {
 	r.RLock()
 	defer r.RUnlock()
 
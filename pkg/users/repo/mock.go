@@ -5,6 +5,7 @@
 package repo
 
 import (
+	context "context"
 	users "golang-stepik-2022q1/reditclone/pkg/users"
 	reflect "reflect"
 
@@ -35,31 +36,31 @@ func (m *MockRepo) EXPECT() *MockRepoMockRecorder {
 }
 
 // Add mocks base method.
-func (m *MockRepo) Add(user *users.User) (int64, error) {
+func (m *MockRepo) Add(ctx context.Context, user *users.User) (int64, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "Add", user)
+	ret := m.ctrl.Call(m, "Add", ctx, user)
 	ret0, _ := ret[0].(int64)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // Add indicates an expected call of Add.
-func (mr *MockRepoMockRecorder) Add(user interface{}) *gomock.Call {
+func (mr *MockRepoMockRecorder) Add(ctx, user interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockRepo)(nil).Add), user)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Add", reflect.TypeOf((*MockRepo)(nil).Add), ctx, user)
 }
 
 // GetByName mocks base method.
-func (m *MockRepo) GetByName(arg0 string) (*users.User, error) {
+func (m *MockRepo) GetByName(ctx context.Context, name string) (*users.User, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetByName", arg0)
+	ret := m.ctrl.Call(m, "GetByName", ctx, name)
 	ret0, _ := ret[0].(*users.User)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetByName indicates an expected call of GetByName.
-func (mr *MockRepoMockRecorder) GetByName(arg0 interface{}) *gomock.Call {
+func (mr *MockRepoMockRecorder) GetByName(ctx, name interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByName", reflect.TypeOf((*MockRepo)(nil).GetByName), arg0)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetByName", reflect.TypeOf((*MockRepo)(nil).GetByName), ctx, name)
 }
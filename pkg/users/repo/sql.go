@@ -1,8 +1,13 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
 	"golang-stepik-2022q1/reditclone/pkg/users"
+	"time"
 )
 
 type RepoSql struct {
@@ -13,12 +18,17 @@ func NewSql(db *sql.DB) *RepoSql {
 	return &RepoSql{db: db}
 }
 
-func (repo *RepoSql) GetByName(name string) (*users.User, error) {
+func (repo *RepoSql) GetByName(ctx context.Context, name string) (_ *users.User, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "users.GetByName", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "users.GetByName")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.ReadContext(ctx)
+	defer cancel()
 	user := &users.User{}
 
-	err := repo.db.
-		QueryRow(`SELECT id, name, pass_hash FROM users WHERE name = $1`, name).
-		Scan(&user.Id, &user.Name, &user.PassHash)
+	err = repo.db.
+		QueryRowContext(ctx, `SELECT id, name, pass_hash, role FROM users WHERE name = $1`, name).
+		Scan(&user.Id, &user.Name, &user.PassHash, &user.Role)
 	if err == sql.ErrNoRows {
 		// users not found - it's not an error
 		return nil, nil
@@ -29,12 +39,18 @@ func (repo *RepoSql) GetByName(name string) (*users.User, error) {
 	return user, nil
 }
 
-func (repo *RepoSql) Add(u *users.User) (int64, error) {
+func (repo *RepoSql) Add(ctx context.Context, u *users.User) (_ int64, err error) {
+	defer metrics.ObserveRepo(metrics.Sql, "users.Add", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Sql, "users.Add")
+	defer tracing.End(span, &err)
+	ctx, cancel := db.WriteContext(ctx)
+	defer cancel()
 	var lastInsertId int64
-	err := repo.db.QueryRow(
-		`INSERT INTO users ("name", "pass_hash") VALUES ($1, $2) RETURNING id`,
+	err = repo.db.QueryRowContext(ctx,
+		`INSERT INTO users ("name", "pass_hash", "role") VALUES ($1, $2, $3) RETURNING id`,
 		u.Name,
 		u.PassHash,
+		u.Role,
 	).Scan(&lastInsertId)
 	if err != nil {
 		return 0, err
@@ -1,6 +1,7 @@
 package repo
 
 import (
+	"context"
 	"database/sql"
 	"database/sql/driver"
 	"errors"
@@ -65,7 +66,7 @@ func (s *Suite) SetupMock(opts MockOpts) {
 func (s *Suite) TestGetByName() {
 	var name = "John"
 	var dbErr = errors.New("Some db error")
-	john := &users.User{1, name, "hashedPass"}
+	john := &users.User{1, name, "hashedPass", users.RoleUser}
 
 	for _, tt := range [...]struct {
 		opts          MockOpts
@@ -75,12 +76,12 @@ func (s *Suite) TestGetByName() {
 		// single users returned
 		{
 			opts: MockOpts{
-				query: "SELECT id, name, pass_hash FROM users",
+				query: "SELECT id, name, pass_hash, role FROM users",
 				args:  []driver.Value{name},
 				rows: &MockRows{
-					[]string{"id", "name", "pass_hash"},
+					[]string{"id", "name", "pass_hash", "role"},
 					[][]driver.Value{
-						{john.Id, john.Name, john.PassHash},
+						{john.Id, john.Name, john.PassHash, john.Role},
 					},
 				},
 			},
@@ -89,7 +90,7 @@ func (s *Suite) TestGetByName() {
 		// no rows not lead to repo error
 		{
 			opts: MockOpts{
-				query: "SELECT id, name, pass_hash FROM users",
+				query: "SELECT id, name, pass_hash, role FROM users",
 				args:  []driver.Value{name},
 				err:   sql.ErrNoRows,
 			},
@@ -97,7 +98,7 @@ func (s *Suite) TestGetByName() {
 		// unexpected error proxied
 		{
 			opts: MockOpts{
-				query: "SELECT id, name, pass_hash FROM users",
+				query: "SELECT id, name, pass_hash, role FROM users",
 				args:  []driver.Value{name},
 				err:   dbErr,
 			},
@@ -105,7 +106,7 @@ func (s *Suite) TestGetByName() {
 		},
 	} {
 		s.SetupMock(tt.opts)
-		item, err := s.repo.GetByName(name)
+		item, err := s.repo.GetByName(context.Background(), name)
 		s.Equal(tt.expectedError, err)
 		s.Equal(tt.expected, item)
 	}
@@ -114,7 +115,7 @@ func (s *Suite) TestGetByName() {
 func (s *Suite) TestAdd() {
 	var dbErr = errors.New("Some db error")
 	var insertId int64 = 123
-	johnIn := &users.User{Name: "John", PassHash: "hashedPass"}
+	johnIn := &users.User{Name: "John", PassHash: "hashedPass", Role: users.RoleUser}
 
 	for _, tt := range [...]struct {
 		name          string
@@ -127,12 +128,12 @@ func (s *Suite) TestAdd() {
 	} {
 		s.mock.
 			ExpectQuery("INSERT INTO users").
-			WithArgs(johnIn.Name, johnIn.PassHash).
+			WithArgs(johnIn.Name, johnIn.PassHash, johnIn.Role).
 			WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(insertId)).
 			WillReturnError(tt.expectedError)
 
 		s.Run(tt.name, func() {
-			item, err := s.repo.Add(johnIn)
+			item, err := s.repo.Add(context.Background(), johnIn)
 			s.Equal(tt.expectedError, err)
 			s.Equal(tt.expected, item)
 		})
@@ -163,7 +164,7 @@ func TestSuite(t *testing.T) {
 //		},
 //	})
 //
-//	item, err := s.repo.GetByName(name)
+//	item, err := s.repo.GetByName(context.Background(), name)
 //	s.NoError(err)
 //	s.Equal(item, users)
 //}
@@ -177,7 +178,7 @@ func TestSuite(t *testing.T) {
 //		err:   sql.ErrNoRows,
 //	})
 //
-//	item, err := s.repo.GetByName(name)
+//	item, err := s.repo.GetByName(context.Background(), name)
 //	s.Nil(err)
 //	s.Nil(item)
 //}
@@ -192,7 +193,7 @@ func TestSuite(t *testing.T) {
 //		err:   dbErr,
 //	})
 //
-//	item, err := s.repo.GetByName(name)
+//	item, err := s.repo.GetByName(context.Background(), name)
 //	s.Equal(err, dbErr)
 //	s.Nil(item)
 //}
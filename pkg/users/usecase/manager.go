@@ -2,18 +2,22 @@ package usecase
 
 import (
 	"context"
-	errors2 "errors"
 	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
 	"golang-stepik-2022q1/reditclone/pkg/users"
 	"golang.org/x/crypto/bcrypt"
 )
 
-var UserExistsError = errors2.New("UserId exists")
+var (
+	UserExistsError = errors.ConflictError{Details: "User already exists"}
+	// BadCredentialsError doesn't tell whether username or password is wrong
+	BadCredentialsError = errors.UnauthenticatedError{Details: "Invalid username or password"}
+)
 
 type Repo interface {
-	Add(user *users.User) (int64, error)
-	GetByName(string) (*users.User, error)
+	Add(ctx context.Context, user *users.User) (int64, error)
+	GetByName(ctx context.Context, name string) (*users.User, error)
 }
 
 type Manager struct {
@@ -24,11 +28,13 @@ func NewManager(repo Repo) *Manager {
 	return &Manager{repo: repo}
 }
 
-func (m *Manager) Create(ctx context.Context, in *users.UserIn) (*users.User, error) {
-	u, err := m.repo.GetByName(in.Name)
+func (m *Manager) Create(ctx context.Context, in *users.UserIn) (_ *users.User, err error) {
+	ctx, span := tracing.Start(ctx, "users.Manager.Create")
+	defer tracing.End(span, &err)
+	u, err := m.repo.GetByName(ctx, in.Name)
 	if err != nil {
 		log.Clog(ctx).Error("User repo error", log.Fields{"error": err.Error()})
-		return nil, errors.InternalError{err.Error()}
+		return nil, errors.InternalError{Details: err.Error()}
 	}
 	if u != nil {
 		log.Clog(ctx).Info("UserId exist")
@@ -39,21 +45,44 @@ func (m *Manager) Create(ctx context.Context, in *users.UserIn) (*users.User, er
 	u = &users.User{
 		Name:     in.Name,
 		PassHash: hashPass,
+		Role:     users.RoleUser,
 	}
-	lastId, err := m.repo.Add(u)
+	lastId, err := m.repo.Add(ctx, u)
 	if err != nil {
-		return nil, errors.InternalError{err.Error()}
+		log.Clog(ctx).Error("User repo error", log.Fields{"error": err.Error()})
+		return nil, errors.InternalError{Details: err.Error()}
 	}
 	u.Id = int(lastId)
 	log.Clog(ctx).Info("UserId created", log.Fields{"id": u.Id, "name": u.Name})
 	return u, nil
 }
 
-func (m *Manager) GetByName(ctx context.Context, name string) (*users.User, error) {
-	u, err := m.repo.GetByName(name)
+func (m *Manager) GetByName(ctx context.Context, name string) (_ *users.User, err error) {
+	ctx, span := tracing.Start(ctx, "users.Manager.GetByName")
+	defer tracing.End(span, &err)
+	u, err := m.repo.GetByName(ctx, name)
 	if err != nil {
 		log.Clog(ctx).Error("UserId repo error", log.Fields{"error": err.Error()})
-		return nil, errors.InternalError{err.Error()}
+		return nil, errors.InternalError{Details: err.Error()}
+	}
+	return u, nil
+}
+
+// Authenticate returns user with matching username and password
+func (m *Manager) Authenticate(ctx context.Context, name, password string) (_ *users.User, err error) {
+	ctx, span := tracing.Start(ctx, "users.Manager.Authenticate")
+	defer tracing.End(span, &err)
+	u, err := m.GetByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		log.Clog(ctx).Info("Login of unknown user", log.Fields{"name": name})
+		return nil, BadCredentialsError
+	}
+	if !CheckPassword(u.PassHash, password) {
+		log.Clog(ctx).Info("Login with invalid password", log.Fields{"name": name})
+		return nil, BadCredentialsError
 	}
 	return u, nil
 }
@@ -16,7 +16,7 @@ func TestManager_GetByName(t *testing.T) {
 	defer ctrl.Finish()
 
 	name := "John"
-	user := &users.User{1, name, "password"}
+	user := &users.User{1, name, "password", users.RoleUser}
 
 	for _, tt := range [...]struct {
 		name    string
@@ -27,17 +27,17 @@ func TestManager_GetByName(t *testing.T) {
 		{
 			name: "Ok",
 			setup: func(st *repo.MockRepo) {
-				st.EXPECT().GetByName(name).Return(user, nil)
+				st.EXPECT().GetByName(gomock.Any(), name).Return(user, nil)
 			},
 			want: user,
 		},
 		{
 			name: "Repo error",
 			setup: func(st *repo.MockRepo) {
-				st.EXPECT().GetByName(name).Return(nil, fmt.Errorf("Unexpected error"))
+				st.EXPECT().GetByName(gomock.Any(), name).Return(nil, fmt.Errorf("Unexpected error"))
 			},
 			want:    nil,
-			wantErr: errors.InternalError{"Unexpected error"},
+			wantErr: errors.InternalError{Details: "Unexpected error"},
 		},
 	} {
 		t.Run(tt.name, func(t *testing.T) {
@@ -77,8 +77,8 @@ func TestManager_Create(t *testing.T) {
 			name: "Ok",
 			setup: func(st *repo.MockRepo) {
 				gomock.InOrder(
-					st.EXPECT().GetByName(userData.Name).Return(nil, nil),
-					st.EXPECT().Add(gomock.AssignableToTypeOf(&users.User{})).Return(int64(userId), nil),
+					st.EXPECT().GetByName(gomock.Any(), userData.Name).Return(nil, nil),
+					st.EXPECT().Add(gomock.Any(), gomock.AssignableToTypeOf(&users.User{})).Return(int64(userId), nil),
 				)
 			},
 			want: expectedUser,
@@ -87,25 +87,25 @@ func TestManager_Create(t *testing.T) {
 			name: "Repo add error",
 			setup: func(st *repo.MockRepo) {
 				gomock.InOrder(
-					st.EXPECT().GetByName(userData.Name).Return(nil, nil),
-					st.EXPECT().Add(gomock.AssignableToTypeOf(&users.User{})).Return(int64(0), fmt.Errorf("Unexpected error")),
+					st.EXPECT().GetByName(gomock.Any(), userData.Name).Return(nil, nil),
+					st.EXPECT().Add(gomock.Any(), gomock.AssignableToTypeOf(&users.User{})).Return(int64(0), fmt.Errorf("Unexpected error")),
 				)
 			},
 			want:    nil,
-			wantErr: errors.InternalError{"Unexpected error"},
+			wantErr: errors.InternalError{Details: "Unexpected error"},
 		},
 		{
 			name: "Repo find user error",
 			setup: func(st *repo.MockRepo) {
-				st.EXPECT().GetByName(userData.Name).Return(nil, fmt.Errorf("Unexpected error"))
+				st.EXPECT().GetByName(gomock.Any(), userData.Name).Return(nil, fmt.Errorf("Unexpected error"))
 			},
 			want:    nil,
-			wantErr: errors.InternalError{"Unexpected error"},
+			wantErr: errors.InternalError{Details: "Unexpected error"},
 		},
 		{
 			name: "UserId exists",
 			setup: func(st *repo.MockRepo) {
-				st.EXPECT().GetByName(userData.Name).Return(&users.User{}, nil)
+				st.EXPECT().GetByName(gomock.Any(), userData.Name).Return(&users.User{}, nil)
 			},
 			want:    nil,
 			wantErr: UserExistsError,
@@ -131,6 +131,38 @@ func TestManager_Create(t *testing.T) {
 	}
 }
 
+func TestManager_Authenticate(t *testing.T) {
+	ctrl := gomock.NewController(t)
+	defer ctrl.Finish()
+
+	name := "John"
+	hashPass, _ := HashPass("SuperSecret")
+	user := &users.User{Id: 1, Name: name, PassHash: hashPass, Role: users.RoleUser}
+
+	for _, tt := range [...]struct {
+		name     string
+		password string
+		found    *users.User
+		want     *users.User
+		wantErr  error
+	}{
+		{name: "Ok", password: "SuperSecret", found: user, want: user},
+		{name: "Wrong password", password: "secret", found: user, wantErr: BadCredentialsError},
+		{name: "Unknown user", password: "SuperSecret", wantErr: BadCredentialsError},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			st := repo.NewMockRepo(ctrl)
+			manager := NewManager(st)
+			st.EXPECT().GetByName(gomock.Any(), name).Return(tt.found, nil)
+
+			item, err := manager.Authenticate(context.Background(), name, tt.password)
+
+			assert.Equal(t, tt.want, item)
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
 func TestCheckPassword(t *testing.T) {
 	pass := "SuperSecret"
 	hashPass, _ := HashPass(pass)
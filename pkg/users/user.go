@@ -1,9 +1,21 @@
 package users
 
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator"
+	RoleAdmin     = "admin"
+)
+
 type User struct {
 	Id       int `sql:"AUTO_INCREMENT"`
 	Name     string
 	PassHash string
+	Role     string
+}
+
+// IsModerator reports whether role allows to moderate content
+func IsModerator(role string) bool {
+	return role == RoleModerator || role == RoleAdmin
 }
 
 // incoming data for creating users
@@ -2,29 +2,41 @@ package http_utils
 
 import (
 	"encoding/json"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"net/http"
 )
 
+// ErrorResp is the body of every error response,
+// errors lists invalid fields of the request for validation errors only
 type ErrorResp struct {
-	Message string `json:"message"`
+	Code    errors.Code         `json:"code"`
+	Message string              `json:"message"`
+	Errors  []errors.FieldError `json:"errors,omitempty"`
 }
 
-func HttpError(w http.ResponseWriter, details string, code int) {
+// HttpError responds with status code matching the error code (see errors.Code),
+// errors not from errors package are sent as internal ones without their details
+func HttpError(w http.ResponseWriter, err error) {
+	code := errors.CodeOf(err)
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.Header().Set("X-Content-Type-Options", "nosniff")
-	w.WriteHeader(code)
-	json.NewEncoder(w).Encode(ErrorResp{details})
+	w.WriteHeader(code.Status())
+	json.NewEncoder(w).Encode(ErrorResp{
+		Code:    code,
+		Message: errors.Message(err),
+		Errors:  errors.Fields(err),
+	})
 }
 
 func JsonResp(w http.ResponseWriter, v interface{}, code int) {
 	resp, err := json.Marshal(v)
 	if err != nil {
-		http.Error(w, "Marshaling error", http.StatusInternalServerError)
+		HttpError(w, errors.InternalError{Details: "Marshaling error"})
 		return
 	}
 	w.WriteHeader(code)
 	_, err = w.Write(resp)
 	if err != nil {
-		http.Error(w, "Cant write response", http.StatusInternalServerError)
+		HttpError(w, errors.InternalError{Details: "Cant write response"})
 	}
 }
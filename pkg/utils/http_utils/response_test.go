@@ -0,0 +1,80 @@
+package http_utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHttpError(t *testing.T) {
+	for _, tt := range [...]struct {
+		name   string
+		err    error
+		status int
+		body   ErrorResp
+	}{
+		{
+			name:   "Validation",
+			err:    errors.NewValidation("Invalid limit", "limit", "should be a number"),
+			status: http.StatusUnprocessableEntity,
+			body: ErrorResp{
+				Code:    errors.CodeValidation,
+				Message: "Invalid limit",
+				Errors:  []errors.FieldError{{Param: "limit", Msg: "should be a number"}},
+			},
+		},
+		{
+			name:   "Wrapped not found",
+			err:    fmt.Errorf("fetch: %w", errors.NotFoundError{Resource: "Post"}),
+			status: http.StatusNotFound,
+			body:   ErrorResp{Code: errors.CodeNotFound, Message: "fetch: Post not found"},
+		},
+		{
+			name:   "Conflict",
+			err:    errors.ConflictError{Details: "User already exists"},
+			status: http.StatusConflict,
+			body:   ErrorResp{Code: errors.CodeConflict, Message: "User already exists"},
+		},
+		{
+			name:   "Internal details are hidden",
+			err:    errors.InternalError{Details: "pq: relation \"posts\" does not exist"},
+			status: http.StatusInternalServerError,
+			body:   ErrorResp{Code: errors.CodeInternal, Message: "Internal error"},
+		},
+		{
+			name:   "Unknown error is internal",
+			err:    fmt.Errorf("connection refused"),
+			status: http.StatusInternalServerError,
+			body:   ErrorResp{Code: errors.CodeInternal, Message: "Internal error"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := httptest.NewRecorder()
+			HttpError(resp, tt.err)
+
+			assert.Equal(t, tt.status, resp.Code)
+			body := ErrorResp{}
+			require.NoError(t, json.Unmarshal(resp.Body.Bytes(), &body))
+			assert.Equal(t, tt.body, body)
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	in := &struct {
+		Type  string `json:"type" valid:"in(link|text)"`
+		Title string `json:"title" valid:"runelength(1|3)"`
+	}{Type: "video", Title: "abc"}
+
+	err := Validate(in)
+	require.Error(t, err)
+	assert.Equal(t, errors.CodeValidation, errors.CodeOf(err))
+	fields := errors.Fields(err)
+	require.Len(t, fields, 1)
+	assert.Equal(t, "type", fields[0].Param)
+}
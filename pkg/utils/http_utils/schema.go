@@ -2,6 +2,7 @@ package http_utils
 
 import (
 	"encoding/json"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 	"io/ioutil"
 	"net/http"
 )
@@ -12,7 +13,7 @@ func FromBody[T interface{}](r *http.Request) (*T, error) {
 
 	out := new(T)
 	if err := json.Unmarshal(body, out); err != nil {
-		return nil, err
+		return nil, &errors.ValidationError{Details: "Malformed request body: " + err.Error()}
 	}
 	return out, nil
 }
@@ -1,7 +1,9 @@
 package http_utils
 
 import (
+	errors2 "errors"
 	"github.com/asaskevich/govalidator"
+	"golang-stepik-2022q1/reditclone/pkg/errors"
 )
 
 type Validator interface {
@@ -11,7 +13,7 @@ type Validator interface {
 func Validate(in interface{}) error {
 	_, err := govalidator.ValidateStruct(in)
 	if err != nil {
-		return err
+		return validationError(err)
 	}
 
 	validator, ok := in.(Validator)
@@ -24,3 +26,25 @@ func Validate(in interface{}) error {
 	}
 	return nil
 }
+
+// validationError turns govalidator errors into validation error listing invalid fields by their JSON names
+func validationError(err error) *errors.ValidationError {
+	out := &errors.ValidationError{Details: "Invalid request fields"}
+	var collect func(err error)
+	collect = func(err error) {
+		var fieldErrs govalidator.Errors
+		var fieldErr govalidator.Error
+		switch {
+		case errors2.As(err, &fieldErrs):
+			for _, err := range fieldErrs {
+				collect(err)
+			}
+		case errors2.As(err, &fieldErr):
+			out.Fields = append(out.Fields, errors.FieldError{Param: fieldErr.Name, Msg: fieldErr.Err.Error()})
+		default:
+			out.Fields = append(out.Fields, errors.FieldError{Msg: err.Error()})
+		}
+	}
+	collect(err)
+	return out
+}
@@ -0,0 +1,74 @@
+package views
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MemCounter counts post views deduplicated by viewer per time window in memory.
+// It's used in embedded mode without Redis, viewers are kept exactly, so memory grows with audience
+// of the current window and is released on flush.
+type MemCounter struct {
+	sync.Mutex
+	window time.Duration
+	// window start of the last counted view by post and viewer
+	seen    map[string]time.Time
+	pending map[string]int64
+}
+
+func NewMemCounter(window time.Duration) *MemCounter {
+	return &MemCounter{
+		window:  window,
+		seen:    make(map[string]time.Time),
+		pending: make(map[string]int64),
+	}
+}
+
+// View counts post view, returns true if viewer was not seen in current window
+func (c *MemCounter) View(ctx context.Context, postId, viewer string) (bool, error) {
+	c.Lock()
+	defer c.Unlock()
+
+	key := postId + "\x00" + viewer
+	start := time.Now().Truncate(c.window)
+	if c.seen[key].Equal(start) {
+		return false, nil
+	}
+	c.seen[key] = start
+	c.pending[postId]++
+	return true, nil
+}
+
+// Flush moves pending views to the sink in one batch and forgets viewers of past windows.
+// Views are returned to pending when sink fails, to be flushed next time.
+func (c *MemCounter) Flush(ctx context.Context, sink Sink) (int, error) {
+	c.Lock()
+	pending := c.pending
+	c.pending = make(map[string]int64)
+	start := time.Now().Truncate(c.window)
+	for key, seen := range c.seen {
+		if seen.Before(start) {
+			delete(c.seen, key)
+		}
+	}
+	c.Unlock()
+
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	if err := sink.AddViews(ctx, pending); err != nil {
+		c.Lock()
+		for postId, views := range pending {
+			c.pending[postId] += views
+		}
+		c.Unlock()
+		return 0, err
+	}
+	return len(pending), nil
+}
+
+// Run flushes pending views every interval until ctx is done, then flushes the rest
+func (c *MemCounter) Run(ctx context.Context, interval time.Duration, sink Sink) {
+	run(ctx, interval, c, sink)
+}
@@ -0,0 +1,172 @@
+package views
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"golang-stepik-2022q1/reditclone/pkg/log"
+	"golang-stepik-2022q1/reditclone/pkg/metrics"
+	"golang-stepik-2022q1/reditclone/pkg/tracing"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	windowKeyPrefix = "views:"
+	pendingKey      = "views:pending"
+)
+
+// countScript adds viewer to HyperLogLog of the post for current window
+// and counts the view as pending when viewer was not seen in the window yet
+var countScript = `
+local added = redis.call("PFADD", KEYS[1], ARGV[1])
+redis.call("PEXPIRE", KEYS[1], ARGV[2])
+if added == 1 then
+	redis.call("HINCRBY", KEYS[2], ARGV[3], 1)
+end
+return added`
+
+// popScript atomically takes all pending views, so concurrent flushers never count views twice
+var popScript = `
+local pending = redis.call("HGETALL", KEYS[1])
+redis.call("DEL", KEYS[1])
+return pending`
+
+// Sink stores counted views, e.g. posts repo
+type Sink interface {
+	AddViews(ctx context.Context, views map[string]int64) error
+}
+
+type flusher interface {
+	Flush(ctx context.Context, sink Sink) (int, error)
+}
+
+// Counter counts post views deduplicated by viewer per time window.
+// Viewers are kept in Redis HyperLogLog, so memory usage doesn't depend on audience size,
+// and counted views are accumulated in Redis until flush.
+type Counter struct {
+	client db.IRedisClient
+	window time.Duration
+}
+
+func NewCounter(client db.IRedisClient, window time.Duration) *Counter {
+	return &Counter{client: client, window: window}
+}
+
+// ViewerKey identifies viewer: logged in users by id, anonymous ones by hash of ip and user agent.
+// Behind a proxy remoteAddr is client address only when the proxy is trusted (see middleware.RealIP).
+func ViewerKey(userId int, remoteAddr, userAgent string) string {
+	if userId != 0 {
+		return "u:" + strconv.Itoa(userId)
+	}
+	ip, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		ip = remoteAddr
+	}
+	sum := sha256.Sum256([]byte(ip + "\x00" + userAgent))
+	return "a:" + hex.EncodeToString(sum[:16])
+}
+
+// View counts post view, returns true if viewer was not seen in current window
+func (c *Counter) View(ctx context.Context, postId, viewer string) (_ bool, err error) {
+	defer metrics.ObserveRepo(metrics.Redis, "views.View", time.Now())
+	ctx, span := tracing.StartRepo(ctx, metrics.Redis, "views.View")
+	defer tracing.End(span, &err)
+	// window key lives a bit longer than window to count views near the window end
+	keys := []string{c.windowKey(postId, time.Now()), pendingKey}
+	res, err := c.client.Eval(ctx, countScript, keys, viewer, (2 * c.window).Milliseconds(), postId).Result()
+	if err != nil {
+		return false, err
+	}
+	added, _ := res.(int64)
+	return added == 1, nil
+}
+
+// Flush moves pending views to the sink in one batch.
+// Views are returned to pending when sink fails, to be flushed next time.
+func (c *Counter) Flush(ctx context.Context, sink Sink) (int, error) {
+	res, err := c.client.Eval(ctx, popScript, []string{pendingKey}).Result()
+	if err != nil {
+		return 0, err
+	}
+	pending, err := parsePending(res)
+	if err != nil {
+		return 0, err
+	}
+	if len(pending) == 0 {
+		return 0, nil
+	}
+	if err = sink.AddViews(ctx, pending); err != nil {
+		c.restore(ctx, pending)
+		return 0, err
+	}
+	return len(pending), nil
+}
+
+// Run flushes pending views every interval until ctx is done, then flushes the rest
+func (c *Counter) Run(ctx context.Context, interval time.Duration, sink Sink) {
+	run(ctx, interval, c, sink)
+}
+
+// restore returns not stored views to pending
+func (c *Counter) restore(ctx context.Context, pending map[string]int64) {
+	for postId, views := range pending {
+		err := c.client.Eval(ctx, `return redis.call("HINCRBY", KEYS[1], ARGV[1], ARGV[2])`,
+			[]string{pendingKey}, postId, views).Err()
+		if err != nil {
+			log.Error("Post views lost", log.Fields{"postId": postId, "views": views, "error": err.Error()})
+		}
+	}
+}
+
+func (c *Counter) windowKey(postId string, now time.Time) string {
+	return fmt.Sprintf("%s%s:%d", windowKeyPrefix, postId, now.Truncate(c.window).Unix())
+}
+
+// parsePending parses HGETALL reply of field, value pairs
+func parsePending(res interface{}) (map[string]int64, error) {
+	reply, ok := res.([]interface{})
+	if !ok || len(reply)%2 != 0 {
+		return nil, fmt.Errorf("unexpected pending views reply: %v", res)
+	}
+	pending := make(map[string]int64, len(reply)/2)
+	for i := 0; i < len(reply); i += 2 {
+		postId, _ := reply[i].(string)
+		raw, _ := reply[i+1].(string)
+		views, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("unexpected pending views of %s: %v", postId, reply[i+1])
+		}
+		pending[postId] = views
+	}
+	return pending, nil
+}
+
+// run flushes pending views every interval until ctx is done, then flushes the rest
+func run(ctx context.Context, interval time.Duration, f flusher, sink Sink) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			flush(context.Background(), f, sink)
+			return
+		case <-ticker.C:
+			flush(ctx, f, sink)
+		}
+	}
+}
+
+func flush(ctx context.Context, f flusher, sink Sink) {
+	flushed, err := f.Flush(ctx, sink)
+	if err != nil {
+		log.Error("Cant flush post views", log.Fields{"error": err.Error()})
+		return
+	}
+	if flushed > 0 {
+		log.Debug("Post views flushed", log.Fields{"posts": flushed})
+	}
+}
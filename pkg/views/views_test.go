@@ -0,0 +1,126 @@
+package views
+
+import (
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/go-redis/redis/v8"
+	"github.com/stretchr/testify/assert"
+	"golang-stepik-2022q1/reditclone/pkg/db"
+	"testing"
+	"time"
+)
+
+type sinkStub struct {
+	views map[string]int64
+	err   error
+}
+
+func (s *sinkStub) AddViews(ctx context.Context, views map[string]int64) error {
+	if s.err != nil {
+		return s.err
+	}
+	for postId, count := range views {
+		s.views[postId] += count
+	}
+	return nil
+}
+
+func newCounter(t *testing.T) (*Counter, *miniredis.Miniredis) {
+	srv := miniredis.RunT(t)
+	cli := redis.NewClient(&redis.Options{Addr: srv.Addr()})
+	return NewCounter(db.WrapRedis(cli), time.Hour), srv
+}
+
+func TestViewerKey(t *testing.T) {
+	assert.Equal(t, "u:42", ViewerKey(42, "10.0.0.1:5000", "curl"))
+
+	anon := ViewerKey(0, "10.0.0.1:5000", "curl")
+	assert.Equal(t, anon, ViewerKey(0, "10.0.0.1:6000", "curl"), "port should not matter")
+	assert.NotEqual(t, anon, ViewerKey(0, "10.0.0.2:5000", "curl"))
+	assert.NotEqual(t, anon, ViewerKey(0, "10.0.0.1:5000", "firefox"))
+	assert.NotContains(t, anon, "10.0.0.1")
+}
+
+func TestCounter_ViewDedupe(t *testing.T) {
+	ctx := context.Background()
+	counter, srv := newCounter(t)
+
+	for _, tt := range [...]struct {
+		name   string
+		postId string
+		viewer string
+		added  bool
+	}{
+		{"first view", "post", "u:1", true},
+		{"same viewer", "post", "u:1", false},
+		{"other viewer", "post", "u:2", true},
+		{"same viewer of other post", "other", "u:1", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			added, err := counter.View(ctx, tt.postId, tt.viewer)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.added, added)
+		})
+	}
+
+	sink := &sinkStub{views: map[string]int64{}}
+	flushed, err := counter.Flush(ctx, sink)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, flushed)
+	assert.Equal(t, map[string]int64{"post": 2, "other": 1}, sink.views)
+
+	// nothing is flushed twice
+	flushed, err = counter.Flush(ctx, sink)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, flushed)
+
+	// window keys expire, so next window counts viewer again
+	srv.FastForward(3 * time.Hour)
+	added, err := counter.View(ctx, "post", "u:1")
+	assert.NoError(t, err)
+	assert.True(t, added)
+}
+
+func TestCounter_FlushSinkError(t *testing.T) {
+	ctx := context.Background()
+	counter, _ := newCounter(t)
+
+	_, err := counter.View(ctx, "post", "u:1")
+	assert.NoError(t, err)
+
+	sink := &sinkStub{views: map[string]int64{}, err: errors.New("Unexpected error")}
+	_, err = counter.Flush(ctx, sink)
+	assert.Error(t, err)
+
+	// views are kept until stored
+	sink.err = nil
+	flushed, err := counter.Flush(ctx, sink)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+	assert.Equal(t, map[string]int64{"post": 1}, sink.views)
+}
+
+func TestMemCounter(t *testing.T) {
+	ctx := context.Background()
+	counter := NewMemCounter(time.Hour)
+
+	for _, viewer := range []string{"u:1", "u:1", "u:2"} {
+		_, err := counter.View(ctx, "post", viewer)
+		assert.NoError(t, err)
+	}
+
+	sink := &sinkStub{views: map[string]int64{}, err: errors.New("Unexpected error")}
+	_, err := counter.Flush(ctx, sink)
+	assert.Error(t, err)
+
+	sink.err = nil
+	flushed, err := counter.Flush(ctx, sink)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, flushed)
+	assert.Equal(t, map[string]int64{"post": 2}, sink.views)
+
+	added, err := counter.View(ctx, "post", "u:1")
+	assert.NoError(t, err)
+	assert.False(t, added, "viewer is remembered till the end of window")
+}